@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// auditTimeout bounds the audit write so a slow table doesn't hold up the
+// reply.
+const auditTimeout = time.Second
+
+// auditRecord is one successful mint. It must never contain token values,
+// only their masked fingerprints.
+type auditRecord struct {
+	RequestID    string   `dynamodbav:"request_id"`
+	Timestamp    string   `dynamodbav:"timestamp"`
+	UserID       string   `dynamodbav:"user_id"`
+	UserName     string   `dynamodbav:"user_name"`
+	EnterpriseID string   `dynamodbav:"enterprise_id,omitempty"`
+	ChannelID    string   `dynamodbav:"channel_id"`
+	Environment  string   `dynamodbav:"environment"`
+	Count        int      `dynamodbav:"count"`
+	Fingerprints []string `dynamodbav:"fingerprints,omitempty"`
+	// Canary marks mints made by the scheduled canary rather than a user
+	Canary bool `dynamodbav:"canary,omitempty"`
+}
+
+// defaultAuditIndex is the global secondary index, keyed by environment and
+// timestamp, that exports query unless AUDIT_INDEX overrides it.
+const defaultAuditIndex = "environment-timestamp-index"
+
+// auditWriter puts audit records into the DynamoDB table named by
+// AUDIT_TABLE. A nil *auditWriter skips auditing.
+type auditWriter struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	index  string
+}
+
+func newAuditWriter(sess *session.Session) *auditWriter {
+	table := os.Getenv("AUDIT_TABLE")
+	if table == "" {
+		return nil
+	}
+	index := os.Getenv("AUDIT_INDEX")
+	if index == "" {
+		index = defaultAuditIndex
+	}
+	return &auditWriter{client: dynamodb.New(sess), table: table, index: index}
+}
+
+func (a *auditWriter) write(ctx context.Context, record auditRecord) error {
+	if a == nil {
+		return nil
+	}
+
+	item, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return errors.Wrap(err, "MarshalMap failed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, auditTimeout)
+	defer cancel()
+
+	_, err = a.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(a.table),
+		Item:      item,
+	})
+	return errors.Wrap(err, "PutItem failed")
+}
+
+// query returns every record for environment since the given time, reading
+// all pages of the index.
+func (a *auditWriter) query(ctx context.Context, environment string, since time.Time) ([]auditRecord, error) {
+	var (
+		records []auditRecord
+		pageErr error
+	)
+	err := a.client.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(a.table),
+		IndexName:              aws.String(a.index),
+		KeyConditionExpression: aws.String("environment = :environment AND #timestamp >= :since"),
+		ExpressionAttributeNames: map[string]*string{
+			"#timestamp": aws.String("timestamp"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":environment": {S: aws.String(environment)},
+			":since":       {S: aws.String(since.UTC().Format(time.RFC3339))},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []auditRecord
+		if pageErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); pageErr != nil {
+			return false
+		}
+		records = append(records, items...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if pageErr != nil {
+		return nil, errors.Wrap(pageErr, "UnmarshalListOfMaps failed")
+	}
+	return records, nil
+}
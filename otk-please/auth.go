@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitList parses a comma-separated env var value, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// userAllowed checks userID against AllowedUsers. When no users are listed
+// every user is allowed.
+func (c Config) userAllowed(userID string) bool {
+	return len(c.AllowedUsers) == 0 || contains(c.AllowedUsers, userID)
+}
+
+// enterpriseAllowed checks enterpriseID against AllowedEnterprises. When none
+// are listed, or the command comes from a workspace outside Enterprise Grid
+// and so has no enterprise ID, only the other checks apply.
+func (c Config) enterpriseAllowed(enterpriseID string) bool {
+	return len(c.AllowedEnterprises) == 0 || enterpriseID == "" || contains(c.AllowedEnterprises, enterpriseID)
+}
+
+// isAdmin checks userID against AdminUsers. Unlike AllowedUsers, an empty
+// list means nobody is an admin.
+func (c Config) isAdmin(userID string) bool {
+	return contains(c.AdminUsers, userID)
+}
+
+// envAdmin reports whether userID administers env: global admins administer
+// every environment, an environment's own admins only that one.
+func (c Config) envAdmin(env environment, userID string) bool {
+	return c.isAdmin(userID) || contains(env.adminUsers, userID)
+}
+
+// envAllowed reports whether userID may use env, beyond passing
+// userAllowed. Environments without their own allowlist are open to everyone
+// allowed globally; those with one also admit their admins.
+func (c Config) envAllowed(env environment, userID string) bool {
+	return len(env.allowedUsers) == 0 || contains(env.allowedUsers, userID) || c.envAdmin(env, userID)
+}
+
+// channelAllowed reports whether the environment can be minted from
+// channelID. Environments without an allowlist work from any channel.
+func (e environment) channelAllowed(channelID string) bool {
+	return len(e.allowedChannels) == 0 || contains(e.allowedChannels, channelID)
+}
+
+// formatChannels renders channel IDs as Slack channel links.
+func formatChannels(channelIDs []string) string {
+	links := make([]string, len(channelIDs))
+	for i, id := range channelIDs {
+		links[i] = fmt.Sprintf("<#%v>", id)
+	}
+	return strings.Join(links, ", ")
+}
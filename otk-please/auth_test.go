@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentAuthorization(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{
+		"STAGING_ALLOWED_USERS": "U0002",
+		"STAGING_ADMIN_USERS":   "U0003",
+		"ADMIN_USERS":           "U0004",
+	})
+
+	tests := []struct {
+		user    string
+		demo    bool
+		staging bool
+	}{
+		{user: "U0001", demo: true, staging: false},
+		{user: "U0002", demo: true, staging: true},
+		{user: "U0003", demo: true, staging: true},
+		{user: "U0004", demo: true, staging: true},
+	}
+	for _, tt := range tests {
+		for _, env := range []struct {
+			text    string
+			allowed bool
+		}{{"demo", tt.demo}, {"staging", tt.staging}} {
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm(tt.user, env.text)))
+
+			msg := decodeResponse(t, rec)
+			minted := strings.Contains(msg.Text, "TOKEN1234")
+			if minted != env.allowed {
+				t.Errorf("%v minting %v: reply %q, want allowed %v", tt.user, env.text, msg.Text, env.allowed)
+			}
+			if !env.allowed && msg.ErrorCode != codeUnauthorized {
+				t.Errorf("%v minting %v: error_code = %v, want %v", tt.user, env.text, msg.ErrorCode, codeUnauthorized)
+			}
+		}
+	}
+}
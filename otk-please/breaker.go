@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBreakerThreshold is used when BREAKER_THRESHOLD is unset.
+	defaultBreakerThreshold = 5
+	// defaultBreakerWindow is used when BREAKER_WINDOW_SECONDS is unset.
+	defaultBreakerWindow = time.Minute
+	// defaultBreakerCooldown is used when BREAKER_COOLDOWN_SECONDS is unset.
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// errCircuitOpen is returned instead of calling an upstream that has been
+// failing.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker is shared across warm invocations of the same Lambda instance, so
+// it is only a best-effort guard. configureUpstream replaces it with one
+// built from the Config.
+var breaker = newCircuitBreaker(defaultBreakerThreshold, defaultBreakerWindow, defaultBreakerCooldown)
+
+// circuitBreaker stops calling an environment's upstream for a cooldown once
+// it fails threshold times in a row within window.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+	// tripped is set once the circuit has opened, so the first failure after
+	// the cooldown opens it again straight away
+	tripped bool
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func newCircuitBreaker(threshold int, window, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		circuits:  map[string]*circuit{},
+	}
+}
+
+// allow reports whether the upstream for name may be called.
+func (b *circuitBreaker) allow(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	return !ok || !time.Now().Before(c.openUntil)
+}
+
+// record updates the circuit for name with the outcome of a call.
+func (b *circuitBreaker) record(name string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !unavailable(err) {
+		delete(b.circuits, name)
+		return
+	}
+
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{}
+		b.circuits[name] = c
+	}
+
+	now := time.Now()
+	if c.failures == 0 || now.Sub(c.firstFailure) > b.window {
+		c.failures = 0
+		c.firstFailure = now
+	}
+	c.failures++
+
+	if c.tripped || c.failures >= b.threshold {
+		c.openUntil = now.Add(b.cooldown)
+		c.tripped = true
+		c.failures = 0
+	}
+}
+
+// unavailable reports whether err means the upstream itself is failing, as
+// opposed to rejecting our request or the caller giving up.
+func unavailable(err error) bool {
+	var credErr *credentialsError
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errResponseTooLarge) || errors.As(err, &credErr) {
+		return false
+	}
+	status := upstreamStatus(err)
+	return status == 0 || status >= 500
+}
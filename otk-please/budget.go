@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRetryBudget is used when RETRY_BUDGET is unset.
+	defaultRetryBudget = 20
+	// retryBudgetWindow is how long an empty budget takes to refill.
+	retryBudgetWindow = time.Minute
+)
+
+// retryBudget is shared by every mint on the same Lambda instance, so
+// concurrent commands retrying a recovering upstream can't stampede it.
+// configureUpstream replaces it with one sized by the Config.
+var retryBudget = newRetryBudget(defaultRetryBudget)
+
+// tokenBucket allows up to capacity retries at once, refilling evenly over
+// retryBudgetWindow.
+type tokenBucket struct {
+	capacity float64
+	rate     float64 // per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRetryBudget(capacity int) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		rate:     float64(capacity) / retryBudgetWindow.Seconds(),
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// take spends one retry from the budget, reporting false when it is empty.
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
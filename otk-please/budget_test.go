@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryBudgetUnderConcurrentFailures(t *testing.T) {
+	const mints, budget = 10, 5
+
+	var requests int32
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	maxRetries = 3
+	retryBudget = newRetryBudget(budget)
+
+	var wg sync.WaitGroup
+	for i := 0; i < mints; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getTokenWithRetry(context.Background(), env, "bearer"); upstreamStatus(err) != http.StatusServiceUnavailable {
+				t.Errorf("getTokenWithRetry() = %v, want a 503", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every mint makes its first attempt; retries come out of the shared
+	// budget, which only refills by a fraction in the time the test takes.
+	if n := atomic.LoadInt32(&requests); n < mints+budget || n > mints+budget+1 {
+		t.Errorf("upstream received %v requests, want %v first attempts and %v retries", n, mints, budget)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// tokenCache remembers the tokens last minted for each user and environment
+// in the DynamoDB table named by TOKEN_CACHE_TABLE, so an accidental repeat
+// gets the same tokens back instead of using up more one-time keys. Only
+// environments with a cacheTTL use it. Expired items are cleaned up by the
+// table's TTL on expires_at. A nil *tokenCache caches nothing.
+//
+// Tokens are sealed with AES-GCM under the key in the secret named by
+// TOKEN_CACHE_KEY, so the table never holds them in the clear.
+type tokenCache struct {
+	client  dynamodbiface.DynamoDBAPI
+	table   string
+	secrets secretsProvider
+	keyName string
+}
+
+// cachedTokens is one tokenCache item. Tokens is the sealed JSON of the
+// cachedToken list.
+type cachedTokens struct {
+	Key       string `dynamodbav:"cache_key"`
+	Tokens    []byte `dynamodbav:"tokens"`
+	MintedAt  int64  `dynamodbav:"minted_at"`
+	ExpiresAt int64  `dynamodbav:"expires_at"`
+}
+
+// cachedToken is what the cache keeps of a token: enough to send the same
+// reply again.
+type cachedToken struct {
+	Value     string     `json:"value"`
+	Remaining *int       `json:"remaining,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+}
+
+// tokenCacheKeyLength is the size of the AES-256 key TOKEN_CACHE_KEY holds,
+// base64 encoded.
+const tokenCacheKeyLength = 32
+
+// newTokenCache reads secrets' TOKEN_CACHE_KEY like <KEY>_TOKEN_SOURCE. The
+// cache stays off without one rather than storing tokens in the clear.
+func newTokenCache(sess *session.Session, secrets secretsProvider) *tokenCache {
+	table := os.Getenv("TOKEN_CACHE_TABLE")
+	if table == "" {
+		return nil
+	}
+	keyName := os.Getenv("TOKEN_CACHE_KEY")
+	if keyName == "" {
+		logger.Error("token cache is off: TOKEN_CACHE_KEY is not set")
+		return nil
+	}
+	return &tokenCache{client: dynamodb.New(sess), table: table, secrets: secrets, keyName: keyName}
+}
+
+// aead loads the cache key and returns its AES-GCM cipher.
+func (c *tokenCache) aead(ctx context.Context) (cipher.AEAD, error) {
+	raw, err := c.secrets.secret(ctx, c.keyName)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load token cache key")
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != tokenCacheKeyLength {
+		return nil, errors.Errorf("token cache key must be %v base64-encoded bytes", tokenCacheKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewCipher failed")
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts tokens for the item under key. The key is authenticated too,
+// so sealed tokens can't be moved to another user's item.
+func (c *tokenCache) seal(ctx context.Context, key string, tokens []Token) ([]byte, error) {
+	aead, err := c.aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make([]cachedToken, len(tokens))
+	for i, token := range tokens {
+		cached[i] = cachedToken{Value: token.Value, Remaining: token.Remaining, Expires: token.Expires}
+	}
+	plaintext, err := json.Marshal(cached)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal failed")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "could not generate nonce")
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(key)), nil
+}
+
+// open decrypts the tokens sealed for the item under key.
+func (c *tokenCache) open(ctx context.Context, key string, sealed []byte) ([]Token, error) {
+	aead, err := c.aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed tokens are too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decrypt cached tokens")
+	}
+
+	var cached []cachedToken
+	if err := json.Unmarshal(plaintext, &cached); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal failed")
+	}
+	tokens := make([]Token, len(cached))
+	for i, token := range cached {
+		tokens[i] = Token{Value: token.Value, Remaining: token.Remaining, Expires: token.Expires}
+	}
+	return tokens, nil
+}
+
+// cacheKey identifies a user's tokens for one environment, by display name so
+// each preview slug is cached apart.
+func cacheKey(userID, environment string) string {
+	return userID + ":" + environment
+}
+
+// get returns the tokens cached under key and when they were minted, or nil
+// when there are none or they have expired. DynamoDB deletes expired items
+// lazily, so expires_at is checked here too.
+func (c *tokenCache) get(ctx context.Context, key string) ([]Token, time.Time, error) {
+	out, err := c.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(c.table),
+		Key:            map[string]*dynamodb.AttributeValue{"cache_key": {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "GetItem failed")
+	}
+	if out.Item == nil {
+		return nil, time.Time{}, nil
+	}
+
+	var item cachedTokens
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &item); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "UnmarshalMap failed")
+	}
+	if time.Now().Unix() >= item.ExpiresAt {
+		return nil, time.Time{}, nil
+	}
+
+	tokens, err := c.open(ctx, key, item.Tokens)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return tokens, time.Unix(item.MintedAt, 0), nil
+}
+
+// put caches tokens under key for ttl, or until the first of them expires if
+// that is sooner.
+func (c *tokenCache) put(ctx context.Context, key string, tokens []Token, ttl time.Duration) error {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if expires := expiry(tokens); expires != nil && expires.Before(expiresAt) {
+		expiresAt = *expires
+	}
+
+	sealed, err := c.seal(ctx, key, tokens)
+	if err != nil {
+		return err
+	}
+	item := cachedTokens{Key: key, Tokens: sealed, MintedAt: now.Unix(), ExpiresAt: expiresAt.Unix()}
+
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return errors.Wrap(err, "MarshalMap failed")
+	}
+	_, err = c.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item:      av,
+	})
+	return errors.Wrap(err, "PutItem failed")
+}
+
+// caching reports whether m's environment opted into the cache.
+func (a *app) caching(m *mintRequest) bool {
+	return a.tokenCache != nil && m.env.cacheTTL > 0
+}
+
+// cachedReply answers m with the tokens its user minted within the
+// environment's cacheTTL, when asking for the same number again. The cache
+// fails open: if it can't be read, new tokens are minted.
+func (a *app) cachedReply(ctx context.Context, m *mintRequest) (response, bool) {
+	if !a.caching(m) {
+		return response{}, false
+	}
+
+	tokens, mintedAt, err := a.tokenCache.get(ctx, cacheKey(m.s.UserID, m.env.name))
+	if err != nil {
+		m.log.Error("could not read token cache", "error", err)
+		return response{}, false
+	}
+	if len(tokens) == 0 || len(tokens) != m.cmd.count {
+		return response{}, false
+	}
+
+	m.log.Info("returned cached tokens", "count", len(tokens), "fingerprints", joinFingerprints(a.config.Fingerprint.fingerprints(tokens)))
+
+	age := time.Since(mintedAt).Round(time.Second)
+	note := m.cmd.locale.text(msgCachedTokens, fmt.Sprintf("%vs", int(age.Seconds())))
+	msg := a.deliverTokens(ctx, m, tokens)
+	msg.Text = note + "\n" + msg.Text
+	if len(msg.Blocks.BlockSet) > 0 {
+		text := slack.NewTextBlockObject(slack.MarkdownType, note, false, false)
+		msg.Blocks.BlockSet = append([]slack.Block{slack.NewContextBlock("", text)}, msg.Blocks.BlockSet...)
+	}
+	return msg, true
+}
+
+// cacheTokens remembers freshly minted tokens when m's environment opted in.
+func (a *app) cacheTokens(ctx context.Context, m *mintRequest, tokens []Token) {
+	if !a.caching(m) {
+		return
+	}
+	if err := a.tokenCache.put(ctx, cacheKey(m.s.UserID, m.env.name), tokens, m.env.cacheTTL); err != nil {
+		m.log.Error("could not cache tokens", "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeCacheTable keeps items by cache_key.
+type fakeCacheTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (f *fakeCacheTable) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[aws.StringValue(in.Item["cache_key"].S)] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeCacheTable) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[aws.StringValue(in.Key["cache_key"].S)]}, nil
+}
+
+// testCache is a tokenCache over a fake table, keyed from the environment.
+func testCache(t *testing.T) (*tokenCache, *fakeCacheTable) {
+	t.Helper()
+	t.Setenv("TOKEN_CACHE_SECRET", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, tokenCacheKeyLength)))
+	table := &fakeCacheTable{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	return &tokenCache{client: table, table: "cache", secrets: envSecrets{}, keyName: "TOKEN_CACHE_SECRET"}, table
+}
+
+func TestTokenCacheRoundTrip(t *testing.T) {
+	cache, table := testCache(t)
+	remaining := 4
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tokens := []Token{{Value: "CACHED-TOKEN-1234", Remaining: &remaining, Expires: &expires}}
+
+	if err := cache.put(context.Background(), "U0001:Demo", tokens, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, av := range table.items["U0001:Demo"] {
+		if strings.Contains(av.String(), "CACHED-TOKEN-1234") || bytes.Contains(av.B, []byte("CACHED-TOKEN-1234")) {
+			t.Fatal("the table holds the token in the clear")
+		}
+	}
+
+	got, _, err := cache.get(context.Background(), "U0001:Demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value != "CACHED-TOKEN-1234" {
+		t.Fatalf("get() = %+v, want the cached token", got)
+	}
+	if got[0].Remaining == nil || *got[0].Remaining != 4 || got[0].Expires == nil || !got[0].Expires.Equal(expires) {
+		t.Errorf("get() lost the token's remaining count or expiry: %+v", got[0])
+	}
+
+	// Sealed tokens are bound to their key.
+	table.items["U0002:Demo"] = table.items["U0001:Demo"]
+	table.items["U0002:Demo"]["cache_key"] = &dynamodb.AttributeValue{S: aws.String("U0002:Demo")}
+	if _, _, err := cache.get(context.Background(), "U0002:Demo"); err == nil {
+		t.Error("get() opened tokens sealed for another user")
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	cache, table := testCache(t)
+
+	// The item expires with its first token rather than after the whole TTL.
+	expires := time.Now().Add(30 * time.Minute)
+	if err := cache.put(context.Background(), "U0001:Demo", []Token{{Value: "TOKEN1234", Expires: &expires}}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(table.items["U0001:Demo"]["expires_at"].N), strconv.FormatInt(expires.Unix(), 10); got != want {
+		t.Errorf("expires_at = %v, want %v", got, want)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	if err := cache.put(context.Background(), "U0001:Demo", []Token{{Value: "TOKEN1234", Expires: &expired}}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := cache.get(context.Background(), "U0001:Demo"); err != nil || got != nil {
+		t.Errorf("get() = %+v, %v after the token expired, want a miss", got, err)
+	}
+}
+
+func TestCachedReply(t *testing.T) {
+	var mints int32
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		atomic.AddInt32(&mints, 1)
+		return Token{Value: "TOKEN1234"}, nil
+	}), map[string]string{"DEMO_CACHE_SECONDS": "60"})
+	a.tokenCache, _ = testCache(t)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+		if body := rec.Body.String(); !strings.Contains(body, "TOKEN1234") {
+			t.Fatalf("reply %v = %q, want the token", i, body)
+		}
+	}
+	if got := atomic.LoadInt32(&mints); got != 1 {
+		t.Errorf("minted %v times, want the repeat served from the cache", got)
+	}
+}
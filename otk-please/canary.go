@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// canary mints one token against the environment named by
+// CANARY_ENVIRONMENT and records the outcome as a metric, so a broken
+// pipeline shows up before a user reports it. Nothing is posted to Slack and
+// the token is discarded, but the mint used a key claim, so it is audited as
+// a canary. It does nothing when CANARY_ENVIRONMENT is unset.
+func (a *app) canary(ctx context.Context) error {
+	key := os.Getenv("CANARY_ENVIRONMENT")
+	if key == "" {
+		return nil
+	}
+	defer flush(ctx)
+
+	log := logger.With("canary", true, "environment", key)
+	env, matches := a.lookupEnvironment(key)
+	if len(matches) != 1 || !env.enabled() {
+		log.Error("canary environment is not available")
+		emitCanaryMetrics(key, false)
+		return errors.Errorf("canary environment %q is not available", key)
+	}
+
+	start := time.Now()
+	token, err := a.source.Mint(ctx, env)
+	latency := time.Since(start)
+	emitTokenMetrics(env.name, err == nil, latency)
+	emitCanaryMetrics(env.name, err == nil)
+	if err != nil {
+		log.Error("canary failed", "upstream_status", upstreamStatus(err), "error", err)
+		return err
+	}
+
+	err = a.audit.write(ctx, auditRecord{
+		RequestID:    requestID(ctx),
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		UserName:     "canary",
+		Environment:  env.name,
+		Count:        1,
+		Fingerprints: []string{a.config.Fingerprint.fingerprint(token.Value)},
+		Canary:       true,
+	})
+	if err != nil {
+		log.Error("AUDIT FAILURE: could not record canary mint", "error", err)
+	}
+
+	log.Info("canary passed", "latency_ms", latency.Milliseconds())
+	return nil
+}
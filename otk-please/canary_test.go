@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeAuditTable keeps the records put into it.
+type fakeAuditTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu      sync.Mutex
+	records []auditRecord
+}
+
+func (f *fakeAuditTable) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	var record auditRecord
+	if err := dynamodbattribute.UnmarshalMap(in.Item, &record); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, record)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestCanaryIsAudited(t *testing.T) {
+	a := testApp(t, staticSource("CANARY-TOKEN-1234"), map[string]string{"CANARY_ENVIRONMENT": "demo"})
+	table := &fakeAuditTable{}
+	a.audit = &auditWriter{client: table, table: "audit"}
+
+	if err := a.canary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.records) != 1 {
+		t.Fatalf("canary wrote %v audit records, want 1", len(table.records))
+	}
+	record := table.records[0]
+	if !record.Canary || record.Environment != "Demo" || record.Count != 1 {
+		t.Errorf("audit record = %+v, want one Demo canary mint", record)
+	}
+	if len(record.Fingerprints) != 1 || record.Fingerprints[0] == "CANARY-TOKEN-1234" {
+		t.Errorf("fingerprints = %q, want one masked fingerprint", record.Fingerprints)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// command is the parsed form of the slash command text, e.g. "demo 5 public".
+type command struct {
+	environment string
+	count       int
+	// public replies in channel instead of only to the invoking user
+	public bool
+	locale locale
+	// dryRun checks configuration without calling the upstream
+	dryRun bool
+	// qr also uploads each token as a QR code image
+	qr bool
+	// json replies with machine-readable JSON instead of a sentence
+	json bool
+	// args are the remaining words after the environment, e.g. a preview slug
+	args []string
+	// rawArgs are args in their original case, for values like tokens
+	rawArgs []string
+	// hasCount records whether count was given rather than defaulted
+	hasCount bool
+	// revoke invalidates token instead of minting
+	revoke bool
+	token  string
+	// audit exports the last days of audit records instead of minting
+	audit bool
+	days  int
+	// url mints against this upstream instead of a registry environment,
+	// for admins only
+	url string
+	// sequence is every word that isn't a keyword, counts included, in the
+	// order given
+	sequence []string
+	// groups are the environments and counts of a command naming several
+	groups []group
+}
+
+// unicodeReplacer maps what mobile clients substitute for plain ASCII: smart
+// quotes, non-breaking and other typographic spaces, and invisible
+// zero-width characters, which strings.Fields doesn't treat as spaces.
+var unicodeReplacer = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+	"\u00a0", " ", "\u2007", " ", "\u202f", " ",
+	"\u200b", "", "\u200c", "", "\u200d", "", "\u2060", "", "\ufeff", "",
+)
+
+// clean trims text, replaces unicode quotes and spaces with ASCII and
+// collapses runs of whitespace to a single space, keeping its case.
+func clean(text string) string {
+	return strings.Join(strings.Fields(unicodeReplacer.Replace(text)), " ")
+}
+
+// normalize is clean lowercased, so parsing sees one predictable form.
+func normalize(text string) string {
+	return strings.ToLower(clean(text))
+}
+
+// parseCommand reads the keywords (a token count, public, fr or lang=xx,
+// --dry-run, qr, format=json, url=...) from anywhere in the command text
+// and takes the first other word as the environment. The returned command
+// keeps its locale even on error so the error can be reported in the right
+// language.
+func parseCommand(text string) (command, error) {
+	cmd := command{count: 1, locale: english}
+
+	var err error
+	for _, raw := range strings.Fields(clean(text)) {
+		field := strings.ToLower(raw)
+		switch {
+		case field == "public":
+			cmd.public = true
+		case field == "fr" || field == "lang=fr":
+			cmd.locale = french
+		case field == "en" || field == "lang=en":
+			cmd.locale = english
+		case field == "--dry-run":
+			cmd.dryRun = true
+		case field == "qr":
+			cmd.qr = true
+		case field == "format=json":
+			cmd.json = true
+		case field == "format=text":
+			cmd.json = false
+		case strings.HasPrefix(field, "url="):
+			cmd.url = raw[len("url="):]
+		case isNumber(field):
+			count, _ := strconv.Atoi(field)
+			if count < 1 {
+				err = errors.Errorf("invalid token count %v", count)
+			}
+			cmd.count = count
+			cmd.hasCount = true
+			cmd.sequence = append(cmd.sequence, field)
+		case cmd.environment == "":
+			cmd.environment = field
+			cmd.sequence = append(cmd.sequence, field)
+		default:
+			cmd.args = append(cmd.args, field)
+			cmd.rawArgs = append(cmd.rawArgs, raw)
+			cmd.sequence = append(cmd.sequence, field)
+		}
+	}
+
+	return cmd, err
+}
+
+func isNumber(field string) bool {
+	_, err := strconv.Atoi(field)
+	return err == nil
+}
+
+// arg returns the first word after the environment, if any.
+func (c command) arg() string {
+	if len(c.args) == 0 {
+		return ""
+	}
+	return c.args[0]
+}
+
+// isVersion reports whether the command asks which build is deployed.
+func (c command) isVersion() bool {
+	return c.environment == "version"
+}
+
+// isWhoami reports whether the command asks who the caller is.
+func (c command) isWhoami() bool {
+	return c.environment == "whoami"
+}
+
+// isErrors reports whether the command asks for the instance's recent
+// errors.
+func (c command) isErrors() bool {
+	return c.environment == "errors"
+}
+
+// isConfigCheck reports whether the command asks for every environment's
+// configuration to be validated.
+func (c command) isConfigCheck() bool {
+	return c.environment == "config-check"
+}
+
+// isList reports whether the command asks which environments exist.
+func (c command) isList() bool {
+	return c.environment == "envs" || c.environment == "list"
+}
+
+// isHelp reports whether the command asks for usage instead of a token.
+func (c command) isHelp() bool {
+	return c.environment == "" || c.environment == "help" || c.environment == "?"
+}
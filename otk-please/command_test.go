@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandWhitespace(t *testing.T) {
+	tests := []struct {
+		text        string
+		environment string
+		count       int
+	}{
+		{text: "demo", environment: "demo", count: 1},
+		{text: "   demo   ", environment: "demo", count: 1},
+		{text: "\tDEMO \n\n 3  ", environment: "demo", count: 3},
+		{text: "staging" + strings.Repeat(" ", 500) + "2", environment: "staging", count: 2},
+	}
+	for _, tt := range tests {
+		cmd, err := parseCommand(tt.text)
+		if err != nil {
+			t.Errorf("parseCommand(%q) = %v", tt.text, err)
+			continue
+		}
+		if cmd.environment != tt.environment || cmd.count != tt.count {
+			t.Errorf("parseCommand(%q) = %q x%v, want %q x%v", tt.text, cmd.environment, cmd.count, tt.environment, tt.count)
+		}
+	}
+}
+
+func TestInputTooLong(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{"MAX_INPUT_LENGTH": "20"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo "+strings.Repeat("x", 20))))
+
+	msg := decodeResponse(t, rec)
+	if msg.ErrorCode != codeInvalidCommand || msg.Text != english.text(msgInputTooLong) {
+		t.Errorf("reply = %q (%v), want the input too long message", msg.Text, msg.ErrorCode)
+	}
+}
+
+func TestCleanUnicode(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "demo\u00a03", want: "demo 3"},
+		{text: "demo\u202f\u20072", want: "demo 2"},
+		{text: "\u201cdemo\u201d", want: `"demo"`},
+		{text: "\u2018demo\u2019", want: "'demo'"},
+		{text: "de\u200bmo\ufeff", want: "demo"},
+	}
+	for _, tt := range tests {
+		if got := clean(tt.text); got != tt.want {
+			t.Errorf("clean(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	cmd, err := parseCommand("Staging\u00a03\u200b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.environment != "staging" || cmd.count != 3 {
+		t.Errorf("parseCommand() = %q x%v, want staging x3", cmd.environment, cmd.count)
+	}
+}
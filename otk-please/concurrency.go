@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultSensitiveConcurrency caps concurrent mints against sensitive
+// environments unless <KEY>_MAX_CONCURRENT overrides it. Other environments
+// are unlimited by default.
+const defaultSensitiveConcurrency = 2
+
+// concurrencyWait is how long a command queues for a free slot before it is
+// turned away.
+const concurrencyWait = 500 * time.Millisecond
+
+// mintGuard limits how many commands mint against each environment at once,
+// so a burst can't drain a key batch. Lambda runs many instances and each has
+// its own guard, so this is a per-instance safeguard, not a global limit.
+type mintGuard struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// inFlight is shared by every invocation on this instance.
+var inFlight = &mintGuard{slots: map[string]chan struct{}{}}
+
+// acquire waits up to concurrencyWait for one of env's slots. It returns a
+// release func, or false when the environment stayed busy. Environments
+// without a limit always succeed.
+func (g *mintGuard) acquire(ctx context.Context, env environment) (func(), bool) {
+	if env.maxConcurrent <= 0 {
+		return func() {}, true
+	}
+
+	g.mu.Lock()
+	slots, ok := g.slots[env.name]
+	if !ok {
+		slots = make(chan struct{}, env.maxConcurrent)
+		g.slots[env.name] = slots
+	}
+	g.mu.Unlock()
+
+	timer := time.NewTimer(concurrencyWait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMintsAreCapped(t *testing.T) {
+	const limit, commands = 2, 5
+
+	previous := inFlight
+	inFlight = &mintGuard{slots: map[string]chan struct{}{}}
+	t.Cleanup(func() { inFlight = previous })
+
+	var (
+		mu           sync.Mutex
+		active, most int
+		unblock      = make(chan struct{})
+	)
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		mu.Lock()
+		active++
+		if active > most {
+			most = active
+		}
+		mu.Unlock()
+		<-unblock
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return Token{Value: "TOKEN1234"}, nil
+	}), map[string]string{"DEMO_MAX_CONCURRENT": "2"})
+
+	replies := make(chan *httptest.ResponseRecorder, commands)
+	for i := 0; i < commands; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+			replies <- rec
+		}()
+	}
+
+	// Every command over the limit gives up after concurrencyWait while the
+	// first ones are still minting.
+	busy := english.text(msgTooConcurrent)
+	for i := 0; i < commands-limit; i++ {
+		if msg := decodeResponse(t, <-replies); msg.Text != busy {
+			t.Errorf("reply = %q, want the busy message", msg.Text)
+		}
+	}
+	close(unblock)
+	for i := 0; i < limit; i++ {
+		if msg := decodeResponse(t, <-replies); !strings.Contains(msg.Text, "TOKEN1234") {
+			t.Errorf("reply = %q, want a token", msg.Text)
+		}
+	}
+	mu.Lock()
+	ran := most
+	mu.Unlock()
+	if ran != limit {
+		t.Errorf("%v mints ran at once, want %v", ran, limit)
+	}
+
+	// The slots were released, so the next command mints straight away.
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+	if msg := decodeResponse(t, rec); !strings.Contains(msg.Text, "TOKEN1234") {
+		t.Errorf("reply after release = %q, want a token", msg.Text)
+	}
+}
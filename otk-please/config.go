@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultMaxTokens is used when MAX_TOKENS is unset.
+	defaultMaxTokens = 10
+	// defaultReplayWindow is used when REPLAY_WINDOW_SECONDS is unset.
+	defaultReplayWindow = 5 * time.Minute
+	// defaultMaxInputLength is used when MAX_INPUT_LENGTH is unset.
+	defaultMaxInputLength = 200
+	// defaultMaxBodyBytes is used when MAX_BODY_BYTES is unset. Slash
+	// commands are far smaller, but interaction payloads carry the message
+	// they came from.
+	defaultMaxBodyBytes = 32 << 10
+	// defaultAdminSecretName is used when ADMIN_BEARER_SECRET is unset.
+	defaultAdminSecretName = "ADMIN"
+)
+
+// Config holds the settings the handler depends on. It is read once at
+// startup and injected so tests can supply their own.
+type Config struct {
+	// SigningSecret verifies requests from every workspace unless
+	// per-workspace secrets are configured
+	SigningSecret string
+	// PreviousSigningSecret is still accepted alongside SigningSecret while
+	// it is rotated out
+	PreviousSigningSecret string
+	// SigningSecrets is a JSON object of Slack team ID to signing secret
+	SigningSecrets string
+	// SigningSecretsName names a secret holding the same JSON object in the
+	// secrets backend, used when SigningSecrets is empty
+	SigningSecretsName string
+	// ReplayWindow is how old a Slack request timestamp may be. slack-go's
+	// verifier also rejects anything over five minutes, so only shorter
+	// windows take effect
+	ReplayWindow time.Duration
+	// MaxTokens caps how many tokens one command can mint
+	MaxTokens int
+	// MaxInputLength caps the command text, in bytes, before it is parsed
+	MaxInputLength int
+	// MaxBodyBytes caps the request body read for verification
+	MaxBodyBytes int
+	// PlainText skips Block Kit for clients that don't render it
+	PlainText bool
+	// Precheck probes an environment's health path before minting
+	Precheck bool
+	// AllowedUsers restricts the command to these Slack user IDs; empty
+	// means every user
+	AllowedUsers []string
+	// AllowedEnterprises restricts Enterprise Grid commands to these
+	// organization IDs; empty means any
+	AllowedEnterprises []string
+	// AdminUsers may run admin commands such as audit exports
+	AdminUsers []string
+	// AdminSecretName names the bearer token used for admins' url=
+	// overrides
+	AdminSecretName string
+	// DefaultEnvironment is used when the command names no environment;
+	// empty shows help instead
+	DefaultEnvironment string
+	// Fingerprint masks tokens for logs and audit records
+	Fingerprint fingerprinter
+	// LogLevel is the minimum level logged, from LOG_LEVEL
+	LogLevel slog.Level
+	// Commands routes each slash command sharing this function
+	Commands commandRoutes
+	// Features switches subcommands off while they roll out
+	Features featureFlags
+	// RateLimitTable holds per-user mint counts; empty disables rate
+	// limiting
+	RateLimitTable string
+	// RateLimitMax caps how many tokens a user can mint per RateLimitWindow
+	RateLimitMax    int
+	RateLimitWindow time.Duration
+	// BreakerThreshold failures in a row within BreakerWindow stop calls to
+	// an upstream for BreakerCooldown
+	BreakerThreshold int
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+	// MaxRetries is how many times a failed upstream call is retried
+	MaxRetries int
+	// RetryBudget caps the retries made per minute by one instance
+	RetryBudget int
+	// UpstreamTimeout bounds each upstream request
+	UpstreamTimeout time.Duration
+	// MaxResponseBytes caps an upstream response body
+	MaxResponseBytes int64
+	// AllowedUpstreamHosts are the domain suffixes upstream requests may go
+	// to
+	AllowedUpstreamHosts []string
+	Environments         map[string]environment
+}
+
+// positiveInt reads the environment variable name as a positive integer,
+// returning fallback when it is unset.
+func positiveInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("%v must be a positive integer, got %q", name, value)
+	}
+	return n, nil
+}
+
+// nonNegativeInt is positiveInt allowing zero.
+func nonNegativeInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("%v must be a non-negative integer, got %q", name, value)
+	}
+	return n, nil
+}
+
+// positiveSeconds reads the environment variable name as a positive number
+// of seconds, returning fallback when it is unset.
+func positiveSeconds(name string, fallback time.Duration) (time.Duration, error) {
+	seconds, err := positiveInt(name, int(fallback/time.Second))
+	return time.Duration(seconds) * time.Second, err
+}
+
+// parseLogLevel reads LOG_LEVEL as debug, info, warn or error, defaulting to
+// info.
+func parseLogLevel(value string) (slog.Level, error) {
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, errors.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", value)
+	}
+	return level, nil
+}
+
+// loadConfig reads the Config from the Lambda's environment variables once at
+// startup. Every problem found is reported together so a misconfigured
+// deployment fails its cold start with one clear message.
+func loadConfig() (Config, error) {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	maxTokens, err := positiveInt("MAX_TOKENS", defaultMaxTokens)
+	check(err)
+	maxInputLength, err := positiveInt("MAX_INPUT_LENGTH", defaultMaxInputLength)
+	check(err)
+	maxBodyBytes, err := positiveInt("MAX_BODY_BYTES", defaultMaxBodyBytes)
+	check(err)
+	replaySeconds, err := positiveInt("REPLAY_WINDOW_SECONDS", int(defaultReplayWindow/time.Second))
+	check(err)
+	rateLimitMax, err := positiveInt("RATE_LIMIT_MAX", defaultRateLimitMax)
+	check(err)
+	rateLimitWindow, err := positiveSeconds("RATE_LIMIT_WINDOW_SECONDS", defaultRateLimitWindow)
+	check(err)
+	breakerThreshold, err := positiveInt("BREAKER_THRESHOLD", defaultBreakerThreshold)
+	check(err)
+	breakerWindow, err := positiveSeconds("BREAKER_WINDOW_SECONDS", defaultBreakerWindow)
+	check(err)
+	breakerCooldown, err := positiveSeconds("BREAKER_COOLDOWN_SECONDS", defaultBreakerCooldown)
+	check(err)
+	maxRetries, err := nonNegativeInt("MAX_RETRIES", defaultMaxRetries)
+	check(err)
+	retryBudget, err := positiveInt("RETRY_BUDGET", defaultRetryBudget)
+	check(err)
+	upstreamTimeout, err := positiveSeconds("UPSTREAM_TIMEOUT_SECONDS", defaultUpstreamTimeout)
+	check(err)
+	maxResponseBytes, err := positiveInt("MAX_RESPONSE_BYTES", defaultMaxResponseBytes)
+	check(err)
+	envs, err := environmentsFromEnv()
+	check(err)
+	fingerprint, err := newFingerprinter(os.Getenv("TOKEN_FINGERPRINT"), os.Getenv("TOKEN_FINGERPRINT_SALT"))
+	check(err)
+	features, err := parseFeatureFlags(os.Getenv("FEATURE_FLAGS"))
+	check(err)
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	check(err)
+	routes, err := parseCommandRoutes(os.Getenv("SLASH_COMMANDS"))
+	check(err)
+
+	config := Config{
+		SigningSecret:         os.Getenv("SLACK_SIGNING_SECRET"),
+		PreviousSigningSecret: os.Getenv("SLACK_SIGNING_SECRET_PREVIOUS"),
+		SigningSecrets:        os.Getenv("SLACK_SIGNING_SECRETS"),
+		SigningSecretsName:    os.Getenv("SLACK_SIGNING_SECRETS_SECRET"),
+		ReplayWindow:          time.Duration(replaySeconds) * time.Second,
+		MaxTokens:             maxTokens,
+		MaxInputLength:        maxInputLength,
+		MaxBodyBytes:          maxBodyBytes,
+		PlainText:             os.Getenv("PLAIN_TEXT") != "",
+		Precheck:              os.Getenv("PRECHECK_ENABLED") != "",
+		AllowedUsers:          splitList(os.Getenv("ALLOWED_USERS")),
+		AllowedEnterprises:    splitList(os.Getenv("ALLOWED_ENTERPRISES")),
+		AdminUsers:            splitList(os.Getenv("ADMIN_USERS")),
+		AdminSecretName:       os.Getenv("ADMIN_BEARER_SECRET"),
+		DefaultEnvironment:    strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_ENVIRONMENT"))),
+		Fingerprint:           fingerprint,
+		LogLevel:              level,
+		Commands:              routes,
+		Features:              features,
+		RateLimitTable:        os.Getenv("RATE_LIMIT_TABLE"),
+		RateLimitMax:          rateLimitMax,
+		RateLimitWindow:       rateLimitWindow,
+		BreakerThreshold:      breakerThreshold,
+		BreakerWindow:         breakerWindow,
+		BreakerCooldown:       breakerCooldown,
+		MaxRetries:            maxRetries,
+		RetryBudget:           retryBudget,
+		UpstreamTimeout:       upstreamTimeout,
+		MaxResponseBytes:      int64(maxResponseBytes),
+		AllowedUpstreamHosts:  splitList(os.Getenv("ALLOWED_UPSTREAM_HOSTS")),
+		Environments:          envs,
+	}
+
+	if config.AdminSecretName == "" {
+		config.AdminSecretName = defaultAdminSecretName
+	}
+	if len(config.AllowedUpstreamHosts) == 0 {
+		config.AllowedUpstreamHosts = defaultUpstreamHosts
+	}
+
+	switch {
+	case config.SigningSecrets != "":
+		var teams map[string]string
+		if err := json.Unmarshal([]byte(config.SigningSecrets), &teams); err != nil {
+			problems = append(problems, "SLACK_SIGNING_SECRETS must be a JSON object of team ID to secret")
+		}
+	case config.SigningSecret == "" && config.SigningSecretsName == "":
+		problems = append(problems, "SLACK_SIGNING_SECRET is required")
+	}
+
+	check(config.validateEnvironments())
+
+	if len(problems) > 0 {
+		return Config{}, errors.Errorf("invalid configuration: %v", strings.Join(problems, "; "))
+	}
+	return config, nil
+}
+
+// environmentsFromEnv copies the registry and applies per-environment
+// overrides:
+//
+//   - <KEY>_URL (e.g. DEMO_URL) points the environment at another upstream so
+//     local runs and integration tests can use a mock. The host must still be
+//     allowed by ALLOWED_UPSTREAM_HOSTS.
+//   - <KEY>_MAX_CONCURRENT caps concurrent mints; sensitive environments
+//     default to defaultSensitiveConcurrency.
+//   - <KEY>_ALLOWED_USERS and <KEY>_ADMIN_USERS are comma-separated Slack
+//     user IDs allowed to use, and to administer, only this environment.
+//   - <KEY>_HEALTH_PATH replaces the path probed when PRECHECK_ENABLED is
+//     set.
+//   - <KEY>_REVOKE_PATH is where the environment's upstream revokes tokens;
+//     revoke is unavailable without one.
+//   - <KEY>_CACHE_SECONDS opts the environment into the token cache.
+//   - <KEY>_TOKEN_SOURCE replaces the name of the bearer token in the
+//     secrets backend, or reads it from a mounted file when it is
+//     file:/path/to/secret.
+//   - <KEY>_EXTRA_HEADERS is a JSON object of headers added to the
+//     environment's upstream requests.
+//   - <KEY>_AUTH_SCHEME sets how the bearer token is sent: bearer, token or
+//     header:<Name>.
+//   - <KEY>_SIGNING_KEY names a secret, read like <KEY>_TOKEN_SOURCE, with
+//     an HMAC key to sign mint requests with. <KEY>_SIGNING_ALGORITHM,
+//     <KEY>_SIGNATURE_HEADER and <KEY>_SIGNATURE_TIMESTAMP_HEADER adjust how.
+//   - <KEY>_TOKEN_PATTERN is a regular expression minted tokens must match,
+//     defaulting to defaultTokenPattern.
+//   - <KEY>_ENABLED=false puts the environment into maintenance.
+//   - <KEY>_DELIVER_AS_FILE sends the environment's tokens as a file.
+//   - <KEY>_REPLY_TEMPLATE and <KEY>_TOKEN_WRAPPER replace the environment's
+//     reply template and token wrapper. Every template is parsed here so a
+//     bad one fails the cold start.
+//
+// ENV_URL_TEMPLATE sets the preview environment's URL template, and
+// PRODUCTION_CHANNELS lists the only channels production can be minted in.
+func environmentsFromEnv() (map[string]environment, error) {
+	envs := make(map[string]environment, len(environments))
+	for key, env := range environments {
+		prefix := strings.ToUpper(key)
+		switch key {
+		case "preview":
+			env.urlTemplate = os.Getenv("ENV_URL_TEMPLATE")
+		case "production":
+			env.allowedChannels = splitList(os.Getenv("PRODUCTION_CHANNELS"))
+		}
+		if override := os.Getenv(prefix + "_URL"); override != "" && env.urlTemplate == "" {
+			env.baseURL = strings.TrimSuffix(override, "/")
+		}
+
+		fallback := 0
+		if env.sensitive {
+			fallback = defaultSensitiveConcurrency
+		}
+		maxConcurrent, err := positiveInt(prefix+"_MAX_CONCURRENT", fallback)
+		if err != nil {
+			return nil, err
+		}
+		env.maxConcurrent = maxConcurrent
+
+		if override := splitList(os.Getenv(prefix + "_ALLOWED_USERS")); len(override) > 0 {
+			env.allowedUsers = override
+		}
+		if override := splitList(os.Getenv(prefix + "_ADMIN_USERS")); len(override) > 0 {
+			env.adminUsers = override
+		}
+
+		if override := os.Getenv(prefix + "_HEALTH_PATH"); override != "" {
+			env.healthPath = override
+		}
+		if override := os.Getenv(prefix + "_REVOKE_PATH"); override != "" {
+			env.revokePath = override
+		}
+
+		cacheSeconds, err := positiveInt(prefix+"_CACHE_SECONDS", int(env.cacheTTL/time.Second))
+		if err != nil {
+			return nil, err
+		}
+		env.cacheTTL = time.Duration(cacheSeconds) * time.Second
+
+		if override := os.Getenv(prefix + "_TOKEN_SOURCE"); override != "" {
+			env.secretName = override
+		}
+
+		if override := os.Getenv(prefix + "_EXTRA_HEADERS"); override != "" {
+			var headers map[string]string
+			if err := json.Unmarshal([]byte(override), &headers); err != nil {
+				return nil, errors.Errorf("%v_EXTRA_HEADERS must be a JSON object of header to value", prefix)
+			}
+			env.extraHeaders = headers
+		}
+		if err := env.validateHeaders(); err != nil {
+			return nil, errors.Wrapf(err, "extra headers for %v", key)
+		}
+
+		if override := os.Getenv(prefix + "_AUTH_SCHEME"); override != "" {
+			env.authScheme = override
+		}
+		if err := env.validateAuthScheme(); err != nil {
+			return nil, errors.Wrapf(err, "auth scheme for %v", key)
+		}
+
+		env.signingKeyName = os.Getenv(prefix + "_SIGNING_KEY")
+		env.signingAlgorithm = strings.ToLower(os.Getenv(prefix + "_SIGNING_ALGORITHM"))
+		env.signatureHeader = os.Getenv(prefix + "_SIGNATURE_HEADER")
+		env.signatureTimestampHeader = os.Getenv(prefix + "_SIGNATURE_TIMESTAMP_HEADER")
+		if err := env.validateSigning(); err != nil {
+			return nil, errors.Wrapf(err, "request signing for %v", key)
+		}
+
+		if override := os.Getenv(prefix + "_TOKEN_PATTERN"); override != "" {
+			env.tokenPattern = override
+		}
+		if env.tokenPattern == "" {
+			env.tokenPattern = defaultTokenPattern
+		}
+		if env.tokenFormat, err = regexp.Compile(env.tokenPattern); err != nil {
+			return nil, errors.Wrapf(err, "invalid token pattern for %v", key)
+		}
+
+		if override := os.Getenv(prefix + "_ENABLED"); override != "" {
+			enabled, err := strconv.ParseBool(override)
+			if err != nil {
+				return nil, errors.Errorf("%v_ENABLED must be true or false, got %q", prefix, override)
+			}
+			env.maintenance = !enabled
+		}
+
+		if override := os.Getenv(prefix + "_DELIVER_AS_FILE"); override != "" {
+			if env.deliverAsFile, err = strconv.ParseBool(override); err != nil {
+				return nil, errors.Errorf("%v_DELIVER_AS_FILE must be true or false, got %q", prefix, override)
+			}
+		}
+
+		if override := os.Getenv(prefix + "_REPLY_TEMPLATE"); override != "" {
+			env.replyTemplate = override
+		}
+		if env.replyTemplate != "" {
+			if env.reply, err = parseReplyTemplate(key, env.replyTemplate); err != nil {
+				return nil, err
+			}
+		}
+
+		if override := os.Getenv(prefix + "_TOKEN_WRAPPER"); override != "" {
+			env.tokenWrapper = override
+		}
+		if env.tokenWrapper != "" {
+			if env.wrapper, err = parseTokenWrapper(key, env.tokenWrapper); err != nil {
+				return nil, err
+			}
+		}
+
+		envs[key] = env
+	}
+	return envs, nil
+}
+
+// validateEnvironments requires at least one enabled environment and that
+// DefaultEnvironment names one. Bearer tokens can only be checked here when
+// they come from environment variables or mounted files; Secrets Manager
+// isn't called at startup.
+func (c Config) validateEnvironments() error {
+	var enabled, withToken int
+	defaultFound := c.DefaultEnvironment == ""
+	for key, env := range c.Environments {
+		if key == c.DefaultEnvironment || contains(env.aliases, c.DefaultEnvironment) {
+			defaultFound = true
+		}
+		if !env.enabled() {
+			continue
+		}
+		enabled++
+		if strings.HasPrefix(env.secretName, fileSecretPrefix) {
+			// Mounted files can be checked now, whatever the backend.
+			if _, err := (fileSecrets{}).secret(context.Background(), env.secretName); err != nil {
+				return errors.Wrapf(err, "bearer token for %v", key)
+			}
+			withToken++
+			continue
+		}
+		if os.Getenv(env.secretName) != "" {
+			withToken++
+		}
+	}
+
+	switch {
+	case enabled == 0:
+		return errors.New("no environment is enabled")
+	case os.Getenv("SECRETS_BACKEND") == "env" && withToken == 0:
+		return errors.New("no enabled environment has a bearer token")
+	case !defaultFound:
+		return errors.Errorf("DEFAULT_ENVIRONMENT %q is not an environment", c.DefaultEnvironment)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "missing signing secret",
+			env:  map[string]string{"SLACK_SIGNING_SECRET": ""},
+			want: []string{"SLACK_SIGNING_SECRET is required"},
+		},
+		{
+			name: "malformed number",
+			env:  map[string]string{"MAX_TOKENS": "lots"},
+			want: []string{`MAX_TOKENS must be a positive integer, got "lots"`},
+		},
+		{
+			name: "zero",
+			env:  map[string]string{"REPLAY_WINDOW_SECONDS": "0"},
+			want: []string{`REPLAY_WINDOW_SECONDS must be a positive integer, got "0"`},
+		},
+		{
+			name: "malformed per-workspace secrets",
+			env:  map[string]string{"SLACK_SIGNING_SECRETS": "T0001=secret"},
+			want: []string{"SLACK_SIGNING_SECRETS must be a JSON object"},
+		},
+		{
+			name: "malformed log level",
+			env:  map[string]string{"LOG_LEVEL": "loud"},
+			want: []string{`LOG_LEVEL must be debug, info, warn or error, got "loud"`},
+		},
+		{
+			name: "malformed limits",
+			env: map[string]string{
+				"RATE_LIMIT_MAX":            "many",
+				"RATE_LIMIT_WINDOW_SECONDS": "1h",
+				"BREAKER_THRESHOLD":         "0",
+				"BREAKER_WINDOW_SECONDS":    "-5",
+				"BREAKER_COOLDOWN_SECONDS":  "soon",
+				"RETRY_BUDGET":              "2.5",
+				"UPSTREAM_TIMEOUT_SECONDS":  "5s",
+				"MAX_RESPONSE_BYTES":        "64KB",
+			},
+			want: []string{
+				`RATE_LIMIT_MAX must be a positive integer, got "many"`,
+				`RATE_LIMIT_WINDOW_SECONDS must be a positive integer, got "1h"`,
+				`BREAKER_THRESHOLD must be a positive integer, got "0"`,
+				`BREAKER_WINDOW_SECONDS must be a positive integer, got "-5"`,
+				`BREAKER_COOLDOWN_SECONDS must be a positive integer, got "soon"`,
+				`RETRY_BUDGET must be a positive integer, got "2.5"`,
+				`UPSTREAM_TIMEOUT_SECONDS must be a positive integer, got "5s"`,
+				`MAX_RESPONSE_BYTES must be a positive integer, got "64KB"`,
+			},
+		},
+		{
+			name: "negative retries",
+			env:  map[string]string{"MAX_RETRIES": "-1"},
+			want: []string{`MAX_RETRIES must be a non-negative integer, got "-1"`},
+		},
+		{
+			name: "every problem at once",
+			env:  map[string]string{"SLACK_SIGNING_SECRET": "", "MAX_BODY_BYTES": "-1"},
+			want: []string{"SLACK_SIGNING_SECRET is required", "MAX_BODY_BYTES must be a positive integer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SLACK_SIGNING_SECRET", testSigningSecret)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := loadConfig()
+			if err == nil {
+				t.Fatal("loadConfig() succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("loadConfig() = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", testSigningSecret)
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxTokens != defaultMaxTokens {
+		t.Errorf("MaxTokens = %v, want %v", config.MaxTokens, defaultMaxTokens)
+	}
+	if config.ReplayWindow != defaultReplayWindow {
+		t.Errorf("ReplayWindow = %v, want %v", config.ReplayWindow, defaultReplayWindow)
+	}
+	if config.MaxRetries != defaultMaxRetries || config.BreakerCooldown != defaultBreakerCooldown || config.MaxResponseBytes != defaultMaxResponseBytes {
+		t.Errorf("MaxRetries, BreakerCooldown, MaxResponseBytes = %v, %v, %v; want the defaults", config.MaxRetries, config.BreakerCooldown, config.MaxResponseBytes)
+	}
+	if strings.Join(config.AllowedUpstreamHosts, ",") != strings.Join(defaultUpstreamHosts, ",") {
+		t.Errorf("AllowedUpstreamHosts = %q, want %q", config.AllowedUpstreamHosts, defaultUpstreamHosts)
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", testSigningSecret)
+	t.Setenv("MAX_RETRIES", "0")
+	t.Setenv("RATE_LIMIT_WINDOW_SECONDS", "60")
+	t.Setenv("ALLOWED_UPSTREAM_HOSTS", "example.com, example.org")
+	t.Setenv("ENV_URL_TEMPLATE", "https://{slug}.cdssandbox.xyz")
+	t.Setenv("PRODUCTION_CHANNELS", "C0001,C0002")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxRetries != 0 {
+		t.Errorf("MaxRetries = %v, want 0", config.MaxRetries)
+	}
+	if config.RateLimitWindow != time.Minute {
+		t.Errorf("RateLimitWindow = %v, want 1m", config.RateLimitWindow)
+	}
+	if got := strings.Join(config.AllowedUpstreamHosts, ","); got != "example.com,example.org" {
+		t.Errorf("AllowedUpstreamHosts = %q", config.AllowedUpstreamHosts)
+	}
+	if got := config.Environments["preview"].urlTemplate; got != "https://{slug}.cdssandbox.xyz" {
+		t.Errorf("preview urlTemplate = %q", got)
+	}
+	if got := strings.Join(config.Environments["production"].allowedChannels, ","); got != "C0001,C0002" {
+		t.Errorf("production allowedChannels = %q", got)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, tt := range []struct {
+		level string
+		debug bool
+	}{
+		{level: "", debug: false},
+		{level: "info", debug: false},
+		{level: "debug", debug: true},
+	} {
+		t.Run(tt.level, func(t *testing.T) {
+			var logs bytes.Buffer
+			previous, previousLevel := logger, logLevel.Level()
+			logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: logLevel}))
+			t.Cleanup(func() {
+				logger = previous
+				logLevel.Set(previousLevel)
+			})
+
+			a := testApp(t, staticSource("TOKEN1234"), map[string]string{"LOG_LEVEL": tt.level})
+			logLevel.Set(a.config.LogLevel)
+			a.handler(httptest.NewRecorder(), slashRequest(slashForm("U0001", "demo")))
+
+			if debug := strings.Contains(logs.String(), `"level":"DEBUG"`); debug != tt.debug {
+				t.Errorf("debug lines logged = %v, want %v:\n%v", debug, tt.debug, logs.String())
+			}
+			if !strings.Contains(logs.String(), `"level":"INFO"`) {
+				t.Error("info lines were not logged")
+			}
+		})
+	}
+}
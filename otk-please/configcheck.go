@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configCheck validates every environment in the registry the way a mint
+// would, without minting: the upstream URL, its host against the allowlist,
+// the presence of the bearer token and any signing key, and the reply
+// templates. Secrets are only ever reported as present or missing.
+func (a *app) configCheck(ctx context.Context, l locale) string {
+	keys := make([]string, 0, len(a.config.Environments))
+	for key := range a.config.Environments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{l.text(msgConfigCheckTitle)}
+	for _, key := range keys {
+		env := a.config.Environments[key]
+		if !env.enabled() {
+			lines = append(lines, l.text(msgConfigSkipped, key))
+			continue
+		}
+
+		var problems []string
+		for _, err := range a.checkEnvironment(ctx, key, env) {
+			problems = append(problems, err.Error())
+		}
+		if len(problems) == 0 {
+			lines = append(lines, l.text(msgConfigOK, key))
+			continue
+		}
+		lines = append(lines, l.text(msgConfigError, key, strings.Join(problems, "; ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkEnvironment returns every problem found with env. Templated
+// environments are checked against a sample slug.
+func (a *app) checkEnvironment(ctx context.Context, key string, env environment) []error {
+	var problems []error
+
+	if env.urlTemplate != "" {
+		var err error
+		if env, err = env.withSlug("pr-1"); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if u, err := url.Parse(env.tokenURL()); err != nil || u.Host == "" {
+		problems = append(problems, errors.Errorf("invalid upstream URL %q", env.tokenURL()))
+	} else if err := checkUpstreamHost(env.tokenURL()); err != nil {
+		problems = append(problems, err)
+	}
+
+	bearerToken, err := a.secrets.secret(ctx, env.secretName)
+	switch {
+	case err != nil:
+		problems = append(problems, errors.New("bearer token could not be loaded"))
+	case bearerToken == "":
+		problems = append(problems, errors.New("bearer token is missing"))
+	}
+
+	if env.signingKeyName != "" {
+		if signingKey, err := a.secrets.secret(ctx, env.signingKeyName); err != nil || signingKey == "" {
+			problems = append(problems, errors.New("signing key is missing"))
+		}
+	}
+
+	if env.replyTemplate != "" {
+		if _, err := parseReplyTemplate(key, env.replyTemplate); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if env.tokenWrapper != "" {
+		if _, err := parseTokenWrapper(key, env.tokenWrapper); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// deliverTokens replies to m with tokens, or with a link to them uploaded as a
+// file when m's environment has deliverAsFile, so they aren't shown in message
+// previews. A failed upload falls back to the tokens inline, with a warning.
+func (a *app) deliverTokens(ctx context.Context, m *mintRequest, tokens []Token) response {
+	if !m.env.deliverAsFile {
+		return a.tokenReply(m, tokens)
+	}
+
+	link, err := uploadTokenFile(ctx, m.cmd.locale, m.env.name, tokens, m.s.UserID)
+	if err != nil {
+		m.log.Warn("could not deliver tokens as a file", "error", err)
+		note := m.cmd.locale.text(msgTokenFileFailed)
+		msg := a.tokenReply(m, tokens)
+		msg.Text = note + "\n" + msg.Text
+		if len(msg.Blocks.BlockSet) > 0 {
+			text := slack.NewTextBlockObject(slack.MarkdownType, note, false, false)
+			msg.Blocks.BlockSet = append([]slack.Block{slack.NewContextBlock("", text)}, msg.Blocks.BlockSet...)
+		}
+		return msg
+	}
+
+	msg := ephemeral(m.cmd.locale.text(msgTokenFile, m.env.label(), link))
+	if m.cmd.public {
+		msg.ResponseType = slack.ResponseTypeInChannel
+	}
+	return msg
+}
+
+// newSlackClient returns a Slack Web API client for botToken. SLACK_API_URL,
+// e.g. "http://localhost:9000/api/", points it at a mock for local runs and
+// tests.
+func newSlackClient(botToken string) *slack.Client {
+	if apiURL := os.Getenv("SLACK_API_URL"); apiURL != "" {
+		return slack.New(botToken, slack.OptionAPIURL(apiURL))
+	}
+	return slack.New(botToken)
+}
+
+// uploadTokenFile uploads tokens, one per line, as a text snippet sent to
+// userID alone by the bot in SLACK_BOT_TOKEN, and returns its permalink.
+func uploadTokenFile(ctx context.Context, l locale, environment string, tokens []Token, userID string) (string, error) {
+	botToken := os.Getenv("SLACK_BOT_TOKEN")
+	if botToken == "" {
+		return "", errors.New("SLACK_BOT_TOKEN is not set")
+	}
+
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString(token.Value + "\n")
+	}
+
+	file, err := newSlackClient(botToken).UploadFileContext(ctx, slack.FileUploadParameters{
+		Content:  b.String(),
+		Filetype: "text",
+		Filename: fmt.Sprintf("%v-tokens.txt", strings.ToLower(environment)),
+		Title:    l.text(msgTokenTitle, environment),
+		Channels: []string{userID},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "UploadFile failed")
+	}
+	return file.Permalink, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testSlackAPI serves auth.test and files.upload like the Slack Web API,
+// keeping every upload's form. ok false makes uploads fail.
+func testSlackAPI(t *testing.T, ok bool) *[]url.Values {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		uploads []url.Values
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/api/auth.test":
+			w.Write([]byte(`{"ok": true, "user_id": "B0001"}`))
+		case "/api/files.upload":
+			req.ParseForm()
+			mu.Lock()
+			uploads = append(uploads, req.PostForm)
+			mu.Unlock()
+			if !ok {
+				w.Write([]byte(`{"ok": false, "error": "not_allowed_token_type"}`))
+				return
+			}
+			w.Write([]byte(`{"ok": true, "file": {"id": "F0001", "permalink": "https://files.slack.com/files-pri/T0001-F0001/demo-tokens.txt"}}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("SLACK_API_URL", srv.URL+"/api/")
+	t.Setenv("SLACK_BOT_TOKEN", "xoxb-test")
+	return &uploads
+}
+
+func TestDeliverAsFile(t *testing.T) {
+	uploads := testSlackAPI(t, true)
+	a := testApp(t, staticSource("FILE-TOKEN-1234"), map[string]string{"DEMO_DELIVER_AS_FILE": "true"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	msg := decodeResponse(t, rec)
+	if strings.Contains(rec.Body.String(), "FILE-TOKEN-1234") {
+		t.Errorf("reply %q shows the token inline", rec.Body)
+	}
+	if !strings.Contains(msg.Text, "https://files.slack.com/files-pri/T0001-F0001/demo-tokens.txt") {
+		t.Errorf("reply %q does not link to the file", msg.Text)
+	}
+	if len(*uploads) != 1 {
+		t.Fatalf("uploaded %v files, want 1", len(*uploads))
+	}
+	upload := (*uploads)[0]
+	if upload.Get("content") != "FILE-TOKEN-1234\n" || upload.Get("channels") != "U0001" {
+		t.Errorf("upload = %v, want the token sent to U0001 alone", upload)
+	}
+}
+
+func TestDeliverAsFileFallsBack(t *testing.T) {
+	testSlackAPI(t, false)
+	a := testApp(t, staticSource("FILE-TOKEN-1234"), map[string]string{"DEMO_DELIVER_AS_FILE": "true"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	msg := decodeResponse(t, rec)
+	if !strings.Contains(msg.Text, english.text(msgTokenFileFailed)) || !strings.Contains(msg.Text, "FILE-TOKEN-1234") {
+		t.Errorf("reply %q, want the token inline with a warning", msg.Text)
+	}
+}
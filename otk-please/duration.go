@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// durationHeader carries how long the handler took, in milliseconds, so
+// slow replies can be told apart from slow upstreams without a trace.
+const durationHeader = "X-Otk-Duration-Ms"
+
+// timedWriter adds durationHeader, measured from start, just before the
+// response status is written.
+type timedWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *timedWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set(durationHeader, strconv.FormatInt(time.Since(w.start).Milliseconds(), 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
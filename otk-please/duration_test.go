@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDurationHeader(t *testing.T) {
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		time.Sleep(20 * time.Millisecond)
+		return Token{Value: "TOKEN1234"}, nil
+	}), nil)
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		min     int64
+	}{
+		{name: "mint", request: slashRequest(slashForm("U0001", "demo")), min: 20},
+		{name: "rejected", request: signedRequest("/", "not-the-secret", time.Now(), slashForm("U0001", "demo").Encode())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.handler(rec, tt.request)
+
+			ms, err := strconv.ParseInt(rec.Header().Get(durationHeader), 10, 64)
+			if err != nil {
+				t.Fatalf("%v = %q, want a number", durationHeader, rec.Header().Get(durationHeader))
+			}
+			if ms < tt.min {
+				t.Errorf("%v = %v, want at least %v", durationHeader, ms, tt.min)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkspace(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       url.Values
+		enterprise string
+		team       string
+	}{
+		{
+			name:       "slash command",
+			body:       url.Values{"team_id": {"T0001"}, "enterprise_id": {"E0001"}},
+			enterprise: "E0001",
+			team:       "T0001",
+		},
+		{
+			name: "outside Enterprise Grid",
+			body: url.Values{"team_id": {"T0001"}},
+			team: "T0001",
+		},
+		{
+			name:       "interaction with a top-level enterprise",
+			body:       url.Values{"payload": {`{"type": "block_actions", "enterprise": {"id": "E0001"}, "team": {"id": "T0001"}}`}},
+			enterprise: "E0001",
+			team:       "T0001",
+		},
+		{
+			name:       "interaction with the enterprise on the team",
+			body:       url.Values{"payload": {`{"type": "block_actions", "enterprise": null, "team": {"id": "T0001", "enterprise_id": "E0001"}}`}},
+			enterprise: "E0001",
+			team:       "T0001",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enterprise, team := workspace([]byte(tt.body.Encode()))
+			if enterprise != tt.enterprise || team != tt.team {
+				t.Errorf("workspace() = %q, %q, want %q, %q", enterprise, team, tt.enterprise, tt.team)
+			}
+		})
+	}
+}
+
+// enterpriseForm is a slash command from workspace T0009 of organization
+// enterpriseID.
+func enterpriseForm(enterpriseID string) url.Values {
+	form := slashForm("U0001", "demo")
+	form.Set("team_id", "T0009")
+	form.Set("enterprise_id", enterpriseID)
+	return form
+}
+
+func TestEnterpriseGrid(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{
+		"SLACK_SIGNING_SECRETS": `{"E0001": "org-secret", "E0002": "other-org-secret"}`,
+		"ALLOWED_ENTERPRISES":   "E0001",
+	})
+	table := &fakeAuditTable{}
+	a.audit = &auditWriter{client: table, table: "audit"}
+
+	// The workspace has no secret of its own, so its organization's is used.
+	rec := httptest.NewRecorder()
+	a.handler(rec, signedRequest("/", "org-secret", time.Now(), enterpriseForm("E0001").Encode()))
+	if msg := decodeResponse(t, rec); !strings.Contains(msg.Text, "TOKEN1234") {
+		t.Fatalf("reply = %q, want a token", msg.Text)
+	}
+	if len(table.records) != 1 || table.records[0].EnterpriseID != "E0001" {
+		t.Errorf("audit records = %+v, want one for E0001", table.records)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handler(rec, signedRequest("/", "other-org-secret", time.Now(), enterpriseForm("E0002").Encode()))
+	if msg := decodeResponse(t, rec); msg.ErrorCode != codeUnauthorized {
+		t.Errorf("reply for another organization = %q (%v), want it refused", msg.Text, msg.ErrorCode)
+	}
+
+	// Another organization's secret doesn't verify this one's requests.
+	rec = httptest.NewRecorder()
+	a.handler(rec, signedRequest("/", "other-org-secret", time.Now(), enterpriseForm("E0001").Encode()))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %v, want 401", rec.Code)
+	}
+}
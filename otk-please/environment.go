@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// environment describes an upstream submission server we can mint key-claim
+// tokens against.
+type environment struct {
+	// name is the display name used in replies
+	name string
+	// aliases are short forms that also select the environment
+	aliases []string
+	// secretName identifies the upstream bearer token in the secrets backend
+	secretName string
+	// secondarySecretName optionally names a second bearer token tried when
+	// the first is rejected, so credentials can be rotated without downtime
+	secondarySecretName string
+	baseURL             string
+	// urlTemplate builds baseURL from a slug given in the command, with
+	// {slug} as the placeholder, for short-lived preview environments
+	urlTemplate string
+	// method and path default to defaultTokenMethod and defaultTokenPath
+	method string
+	path   string
+	// healthPath is probed before minting when PRECHECK_ENABLED is set;
+	// empty means defaultHealthPath
+	healthPath string
+	// revokePath is where tokens are invalidated; empty means the upstream
+	// doesn't support revocation
+	revokePath string
+	// enableEnvVar, when set, must be true for the environment to be usable
+	enableEnvVar string
+	// maintenance keeps the environment listed but refuses to mint, while
+	// its upstream is down for maintenance
+	maintenance bool
+	// allowedChannels restricts minting to these Slack channel IDs; empty
+	// means any channel
+	allowedChannels []string
+	// allowedUsers restricts the environment to these Slack user IDs, on
+	// top of AllowedUsers; empty means anyone allowed globally
+	allowedUsers []string
+	// adminUsers may run admin commands for this environment only, in
+	// addition to the global AdminUsers
+	adminUsers []string
+	// sensitive environments notify the audit channel on every mint
+	sensitive bool
+	// tokenTTL is how long tokens stay valid when the upstream doesn't say;
+	// zero means unknown
+	tokenTTL time.Duration
+	// maxConcurrent caps commands minting at once on this instance; zero
+	// means unlimited
+	maxConcurrent int
+	// replyTemplate is a text/template for the plain text reply, rendered
+	// with replyData; empty means the default reply
+	replyTemplate string
+	// reply is replyTemplate parsed at startup
+	reply *template.Template
+	// extraHeaders are sent on every upstream request, e.g. an API version
+	extraHeaders map[string]string
+	// authScheme is how the bearer token is sent: "bearer" (the default),
+	// "token", or "header:<Name>" for a header of its own
+	authScheme string
+	// tokenWrapper is a text/template presenting .Token, e.g. in a link,
+	// shown alongside the raw token; empty shows the bare token
+	tokenWrapper string
+	// wrapper is tokenWrapper parsed at startup
+	wrapper *template.Template
+	// tokenPattern is what minted tokens must match; empty means
+	// defaultTokenPattern
+	tokenPattern string
+	// tokenFormat is tokenPattern compiled at startup
+	tokenFormat *regexp.Regexp
+	// cacheTTL opts the environment into returning the tokens a user just
+	// minted again, instead of new ones, for a repeat within this long;
+	// zero means off since tokens are single-use
+	cacheTTL time.Duration
+	// emoji is shown before the name in token replies, e.g. ":red_circle:";
+	// empty shows none
+	emoji string
+	// signingKeyName names the secret holding an HMAC key mint requests are
+	// signed with, for upstreams that want more than the bearer token; empty
+	// means unsigned
+	signingKeyName string
+	// signingKey is the key signingKeyName held, loaded for each mint
+	signingKey string
+	// signingAlgorithm is "sha256" (the default) or "sha512"
+	signingAlgorithm string
+	// signatureHeader and signatureTimestampHeader default to
+	// defaultSignatureHeader and defaultSignatureTimestampHeader
+	signatureHeader          string
+	signatureTimestampHeader string
+	// deliverAsFile sends tokens to the user as a text file and replies
+	// with a link, instead of showing them inline
+	deliverAsFile bool
+}
+
+// environments is keyed by the lowercase word users type in the command.
+// Each environment's aliases select it too.
+var environments = map[string]environment{
+	"demo": {
+		name:                "Demo",
+		aliases:             []string{"d"},
+		secretName:          "DEMO",
+		secondarySecretName: "DEMO_SECONDARY",
+		baseURL:             "https://submission.covid-alert-demo.cdssandbox.xyz",
+		tokenTTL:            24 * time.Hour,
+	},
+	"staging": {
+		name:                "Staging",
+		aliases:             []string{"stg", "stage"},
+		secretName:          "STAGING",
+		secondarySecretName: "STAGING_SECONDARY",
+		baseURL:             "https://submission.wild-samphire.cdssandbox.xyz",
+		tokenTTL:            24 * time.Hour,
+		emoji:               ":large_green_circle:",
+	},
+	"production": production,
+	"preview": {
+		name:       "Preview",
+		aliases:    []string{"pr"},
+		secretName: "PREVIEW",
+	},
+}
+
+var production = environment{
+	name:                "Production",
+	aliases:             []string{"prod"},
+	secretName:          "PRODUCTION",
+	secondarySecretName: "PRODUCTION_SECONDARY",
+	baseURL:             "https://submission.covid-notification.alpha.canada.ca",
+	enableEnvVar:        "ENABLE_PRODUCTION",
+	sensitive:           true,
+	tokenTTL:            24 * time.Hour,
+	emoji:               ":red_circle:",
+}
+
+const (
+	defaultTokenMethod = "POST"
+	defaultTokenPath   = "/new-key-claim"
+)
+
+// label is the name as shown in token replies, after the emoji if there is
+// one. It is purely cosmetic; logs and audit records use name.
+func (e environment) label() string {
+	if e.emoji == "" {
+		return e.name
+	}
+	return e.emoji + " " + e.name
+}
+
+// tokenURL is the full upstream URL to mint a token at.
+func (e environment) tokenURL() string {
+	if e.path == "" {
+		return e.baseURL + defaultTokenPath
+	}
+	return e.baseURL + e.path
+}
+
+// defaultTokenPattern matches the tokens upstreams hand out today.
+const defaultTokenPattern = `^[A-Za-z0-9-]{8,64}$`
+
+// errorWords in a "token" mean the upstream sent an error with a 200.
+var errorWords = regexp.MustCompile(`(?i)error|invalid|unauthori[sz]ed|forbidden|denied`)
+
+// errMalformedToken is returned for an upstream response that isn't a token.
+var errMalformedToken = errors.New("upstream returned a malformed token")
+
+// validateToken checks that value looks like one of e's tokens. The value is
+// never included in the error.
+func (e environment) validateToken(value string) error {
+	switch {
+	case value == "":
+		return errors.Wrap(errMalformedToken, "empty token")
+	case e.tokenFormat != nil && !e.tokenFormat.MatchString(value):
+		return errors.Wrapf(errMalformedToken, "token of length %v does not match %v", len(value), e.tokenFormat)
+	case errorWords.MatchString(value):
+		return errors.Wrap(errMalformedToken, "token looks like an error message")
+	}
+	return nil
+}
+
+// reservedHeaders are set by the upstream client and can't be extra headers.
+var reservedHeaders = []string{"Authorization", "Content-Length", "Content-Type", "Host"}
+
+// validHeaderName matches an RFC 7230 header field name.
+var validHeaderName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// validateHeaders checks e's extraHeaders names and values.
+func (e environment) validateHeaders() error {
+	for name, value := range e.extraHeaders {
+		if !validHeaderName.MatchString(name) {
+			return errors.Errorf("invalid header name %q", name)
+		}
+		if containsFold(reservedHeaders, name) {
+			return errors.Errorf("header %q can't be overridden", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("invalid value for header %q", name)
+		}
+	}
+	return nil
+}
+
+// Authentication schemes for authScheme.
+const (
+	authBearer       = "bearer"
+	authToken        = "token"
+	authHeaderPrefix = "header:"
+)
+
+// validateAuthScheme checks e's authScheme.
+func (e environment) validateAuthScheme() error {
+	switch {
+	case e.authScheme == "", e.authScheme == authBearer, e.authScheme == authToken:
+		return nil
+	case strings.HasPrefix(e.authScheme, authHeaderPrefix):
+		name := strings.TrimPrefix(e.authScheme, authHeaderPrefix)
+		if !validHeaderName.MatchString(name) || containsFold(reservedHeaders, name) {
+			return errors.Errorf("invalid auth header %q", name)
+		}
+		return nil
+	}
+	return errors.Errorf("auth scheme must be bearer, token or header:<Name>, got %q", e.authScheme)
+}
+
+// authorize adds bearerToken to req as e's authScheme asks.
+func (e environment) authorize(req *http.Request, bearerToken string) {
+	switch {
+	case e.authScheme == authToken:
+		req.Header.Set("Authorization", fmt.Sprintf("Token %v", bearerToken))
+	case strings.HasPrefix(e.authScheme, authHeaderPrefix):
+		req.Header.Set(strings.TrimPrefix(e.authScheme, authHeaderPrefix), bearerToken)
+	default:
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", bearerToken))
+	}
+}
+
+// setHeaders adds e's extraHeaders to req.
+func (e environment) setHeaders(req *http.Request) {
+	for name, value := range e.extraHeaders {
+		req.Header.Set(name, value)
+	}
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e environment) tokenMethod() string {
+	if e.method == "" {
+		return defaultTokenMethod
+	}
+	return e.method
+}
+
+// previewSlug is the only slug form accepted in preview URLs, so command text
+// can't point requests at arbitrary hosts.
+var previewSlug = regexp.MustCompile(`^pr-\d+$`)
+
+// withSlug resolves a templated environment to the one named by slug.
+func (e environment) withSlug(slug string) (environment, error) {
+	if !previewSlug.MatchString(slug) {
+		return e, errors.Errorf("invalid preview slug %q", slug)
+	}
+	e.baseURL = strings.Replace(e.urlTemplate, "{slug}", slug, -1)
+	e.name = fmt.Sprintf("%v %v", e.name, slug)
+	return e, nil
+}
+
+// enabled reports whether the environment can be used. Environments without
+// an address are off, as are those gated behind an enableEnvVar unless it is
+// explicitly set to true.
+func (e environment) enabled() bool {
+	if e.baseURL == "" && e.urlTemplate == "" {
+		return false
+	}
+	if e.enableEnvVar == "" {
+		return true
+	}
+	enabled, err := strconv.ParseBool(os.Getenv(e.enableEnvVar))
+	return err == nil && enabled
+}
+
+// customEnvironment builds a one-off environment for an admin's url=
+// override. The URL must be https, without a query or fragment, which the
+// mint request would otherwise silently drop, and its host must pass the
+// upstream allowlist. It is treated as sensitive so every mint is
+// announced, and it uses the bearer token named by secretName.
+func customEnvironment(rawURL, secretName string) (environment, error) {
+	// Slack sends links wrapped in angle brackets, e.g. <https://x|x>.
+	rawURL = strings.TrimSuffix(strings.TrimPrefix(rawURL, "<"), ">")
+	if i := strings.Index(rawURL, "|"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return environment{}, errors.Wrap(err, "invalid custom URL")
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return environment{}, errors.Errorf("custom URL %q must be an https URL", rawURL)
+	}
+	if u.RawQuery != "" || u.ForceQuery || u.Fragment != "" {
+		return environment{}, errors.Errorf("custom URL %q must not have a query or fragment", rawURL)
+	}
+	if err := checkUpstreamHost(rawURL); err != nil {
+		return environment{}, err
+	}
+
+	env := environment{
+		name:        fmt.Sprintf("Custom %v", u.Host),
+		secretName:  secretName,
+		baseURL:     u.Scheme + "://" + u.Host,
+		path:        u.Path,
+		sensitive:   true,
+		tokenFormat: regexp.MustCompile(defaultTokenPattern),
+	}
+	return env, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCustomEnvironment(t *testing.T) {
+	restoreUpstream(t)
+	allowedUpstreamHosts = []string{"cdssandbox.xyz"}
+
+	env, err := customEnvironment("<https://submission.cdssandbox.xyz/claim|submission.cdssandbox.xyz/claim>", "ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := env.tokenURL(); got != "https://submission.cdssandbox.xyz/claim" {
+		t.Errorf("tokenURL() = %q", got)
+	}
+
+	for _, rawURL := range []string{
+		"http://submission.cdssandbox.xyz/claim",
+		"https://submission.cdssandbox.xyz/claim?tenant=2",
+		"https://submission.cdssandbox.xyz/claim?",
+		"https://submission.cdssandbox.xyz/claim#section",
+		"https://example.com/claim",
+	} {
+		if _, err := customEnvironment(rawURL, "ADMIN"); err == nil {
+			t.Errorf("customEnvironment(%q) succeeded, want an error", rawURL)
+		}
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		valid   bool
+	}{
+		{headers: map[string]string{"X-Tenant": "covid-alert"}, valid: true},
+		{headers: map[string]string{"authorization": "Bearer other"}},
+		{headers: map[string]string{"Host": "example.com"}},
+		{headers: map[string]string{"Bad Name": "value"}},
+		{headers: map[string]string{"X-Tenant": "value\r\nX-Injected: 1"}},
+	}
+	for _, tt := range tests {
+		err := environment{extraHeaders: tt.headers}.validateHeaders()
+		if (err == nil) != tt.valid {
+			t.Errorf("validateHeaders(%q) = %v, want valid %v", tt.headers, err, tt.valid)
+		}
+	}
+}
+
+func TestEnvironmentEmoji(t *testing.T) {
+	for _, plainText := range []string{"", "true"} {
+		a := testApp(t, staticSource("TOKEN1234"), map[string]string{"PLAIN_TEXT": plainText})
+
+		rec := httptest.NewRecorder()
+		a.handler(rec, slashRequest(slashForm("U0001", "staging")))
+		if body := rec.Body.String(); !strings.Contains(body, ":large_green_circle: Staging") {
+			t.Errorf("PLAIN_TEXT=%q reply %q does not show the staging emoji", plainText, body)
+		}
+	}
+
+	if got := environments["demo"].label(); got != "Demo" {
+		t.Errorf("label() without an emoji = %q, want Demo", got)
+	}
+}
+
+func TestValidateAuthSchemeInvalid(t *testing.T) {
+	for _, scheme := range []string{"basic", "header:", "header:Authorization", "header:Bad Name"} {
+		if err := (environment{authScheme: scheme}).validateAuthScheme(); err == nil {
+			t.Errorf("validateAuthScheme(%q) succeeded, want an error", scheme)
+		}
+	}
+}
+
+func TestUnavailableEnvironments(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		text string
+		want string
+		code errorCode
+	}{
+		{
+			name: "not enabled",
+			env:  map[string]string{"ENABLE_PRODUCTION": ""},
+			text: "production",
+			want: english.text(msgNotEnabled, "Production"),
+			code: codeUnknownEnv,
+		},
+		{
+			name: "maintenance",
+			env:  map[string]string{"DEMO_ENABLED": "false"},
+			text: "demo",
+			want: english.text(msgMaintenance, "Demo"),
+			code: codeUpstreamError,
+		},
+		{
+			name: "back from maintenance",
+			env:  map[string]string{"DEMO_ENABLED": "true"},
+			text: "demo",
+			want: "TOKEN1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minted := false
+			a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+				minted = true
+				return Token{Value: "TOKEN1234"}, nil
+			}), tt.env)
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm("U0001", tt.text)))
+
+			msg := decodeResponse(t, rec)
+			if !strings.Contains(msg.Text, tt.want) || msg.ErrorCode != tt.code {
+				t.Errorf("reply = %q (%v), want %q (%v)", msg.Text, msg.ErrorCode, tt.want, tt.code)
+			}
+			if minted != (tt.code == "") {
+				t.Errorf("minted = %v", minted)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/slack-go/slack"
+)
+
+// errorCode is a stable identifier for a failure that tooling can branch on
+// instead of matching the localized reply text.
+type errorCode string
+
+const (
+	codeUnauthorized   errorCode = "UNAUTHORIZED"
+	codeUnknownEnv     errorCode = "UNKNOWN_ENV"
+	codeInvalidCommand errorCode = "INVALID_COMMAND"
+	codeUpstreamError  errorCode = "UPSTREAM_ERROR"
+	codeRateLimited    errorCode = "RATE_LIMITED"
+	codeTimeout        errorCode = "TIMEOUT"
+	// codeInternalError covers our own configuration and dependencies, such
+	// as missing credentials or an unreadable audit table
+	codeInternalError errorCode = "INTERNAL_ERROR"
+)
+
+// errorCodeHeader carries the errorCode of a failed command.
+const errorCodeHeader = "X-Otk-Error-Code"
+
+// response is a Slack message along with the errorCode of the failure it
+// reports. Slack ignores the extra field.
+type response struct {
+	slack.Msg
+	ErrorCode errorCode `json:"error_code,omitempty"`
+}
+
+// failure builds an ephemeral reply reporting a failure with code.
+func failure(code errorCode, text string) response {
+	msg := ephemeral(text)
+	msg.ErrorCode = code
+	return msg
+}
+
+// setErrorCode adds code to the response headers, unless it is empty.
+func setErrorCode(w http.ResponseWriter, code errorCode) {
+	if code != "" {
+		w.Header().Set(errorCodeHeader, string(code))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMintFailureCodes(t *testing.T) {
+	cmd := command{count: 1, locale: english}
+	env := environment{name: "Demo"}
+	tests := []struct {
+		name      string
+		err       error
+		abandoned bool
+		want      errorCode
+	}{
+		{name: "abandoned", err: context.DeadlineExceeded, abandoned: true, want: codeTimeout},
+		{name: "credentials", err: &credentialsError{err: errors.New("no such secret")}, want: codeInternalError},
+		{name: "circuit open", err: errCircuitOpen, want: codeUpstreamError},
+		{name: "malformed token", err: errors.Wrap(errMalformedToken, "empty token"), want: codeUpstreamError},
+		{name: "busy", err: errors.Wrap(errUpstreamBusy, "asked to wait"), want: codeRateLimited},
+		{name: "certificate", err: x509.UnknownAuthorityError{}, want: codeUpstreamError},
+		{name: "timeout", err: context.DeadlineExceeded, want: codeTimeout},
+		{name: "upstream status", err: &upstreamError{statusCode: http.StatusBadGateway}, want: codeUpstreamError},
+		{name: "network", err: errors.New("connection refused"), want: codeUpstreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mintFailure(cmd, env, tt.err, tt.abandoned)
+			if msg.ErrorCode != tt.want {
+				t.Errorf("mintFailure() code = %v, want %v", msg.ErrorCode, tt.want)
+			}
+			if msg.Text == "" {
+				t.Error("mintFailure() has no text")
+			}
+		})
+	}
+}
+
+func TestErrorCodeHeaderMatchesBody(t *testing.T) {
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		return Token{}, &upstreamError{statusCode: http.StatusServiceUnavailable}
+	}), map[string]string{"MAX_RETRIES": "0"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	msg := decodeResponse(t, rec)
+	if msg.ErrorCode != codeUpstreamError {
+		t.Errorf("error_code = %v, want %v", msg.ErrorCode, codeUpstreamError)
+	}
+	if got := rec.Header().Get(errorCodeHeader); got != string(msg.ErrorCode) {
+		t.Errorf("%v = %q, want %q", errorCodeHeader, got, msg.ErrorCode)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// defaultAuditDays is how far back an audit export looks when no number of
+// days is given.
+const defaultAuditDays = 7
+
+// exportAudit answers "audit <environment> [days]" for admins with a summary
+// of the environment's audit records, and sends the records themselves to the
+// admin as a CSV file. Audit records never hold token values.
+func (a *app) exportAudit(ctx context.Context, m *mintRequest) response {
+	s, cmd, env, log := m.s, m.cmd, m.env, m.log
+
+	if a.audit == nil {
+		return failure(codeInternalError, cmd.locale.text(msgAuditDisabled))
+	}
+
+	since := time.Now().AddDate(0, 0, -cmd.days)
+	records, err := a.audit.query(ctx, env.name, since)
+	if err != nil {
+		log.Error("could not query audit records", "error", err)
+		return failure(codeInternalError, cmd.locale.text(msgAuditFailed, env.name))
+	}
+
+	var tokens int
+	for _, record := range records {
+		tokens += record.Count
+	}
+	log.Info("exported audit records", "records", len(records), "days", cmd.days)
+
+	summary := cmd.locale.text(msgAuditSummary, tokens, env.name, len(records), cmd.days)
+	if len(records) == 0 {
+		return ephemeral(summary)
+	}
+
+	if err := uploadAuditRecords(ctx, env.name, records, s.UserID); err != nil {
+		log.Warn("could not upload audit records", "error", err)
+		return ephemeral(summary + "\n" + cmd.locale.text(msgAuditUploadFailed))
+	}
+	return ephemeral(summary)
+}
+
+// uploadAuditRecords sends records as a CSV file to channel with the bot in
+// SLACK_BOT_TOKEN.
+func uploadAuditRecords(ctx context.Context, environment string, records []auditRecord, channel string) error {
+	botToken := os.Getenv("SLACK_BOT_TOKEN")
+	if botToken == "" {
+		return errors.New("SLACK_BOT_TOKEN is not set")
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"request_id", "timestamp", "user_id", "user_name", "enterprise_id", "channel_id", "environment", "count", "fingerprints", "canary"})
+	for _, record := range records {
+		w.Write([]string{
+			record.RequestID,
+			record.Timestamp,
+			record.UserID,
+			record.UserName,
+			record.EnterpriseID,
+			record.ChannelID,
+			record.Environment,
+			strconv.Itoa(record.Count),
+			joinFingerprints(record.Fingerprints),
+			strconv.FormatBool(record.Canary),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return errors.Wrap(err, "csv failed")
+	}
+
+	_, err := newSlackClient(botToken).UploadFileContext(ctx, slack.FileUploadParameters{
+		Reader:   &b,
+		Filetype: "csv",
+		Filename: fmt.Sprintf("%v-audit.csv", strings.ToLower(environment)),
+		Channels: []string{channel},
+	})
+	return errors.Wrap(err, "UploadFile failed")
+}
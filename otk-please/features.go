@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Features that FEATURE_FLAGS can switch off while they roll out.
+const (
+	featureQR     = "qr"
+	featureMulti  = "multi"
+	featureRevoke = "revoke"
+	featureAudit  = "audit"
+	featureURL    = "url"
+)
+
+var knownFeatures = []string{featureQR, featureMulti, featureRevoke, featureAudit, featureURL}
+
+// featureFlags maps a feature to whether it is on. Features it doesn't list
+// are on, so flags are only needed to hold something back.
+type featureFlags map[string]bool
+
+// parseFeatureFlags reads FEATURE_FLAGS, a JSON object such as
+// {"qr": false}. Unknown features are rejected so a typo can't leave a
+// feature on by accident.
+func parseFeatureFlags(value string) (featureFlags, error) {
+	if value == "" {
+		return featureFlags{}, nil
+	}
+
+	var flags featureFlags
+	if err := json.Unmarshal([]byte(value), &flags); err != nil {
+		return nil, errors.New("FEATURE_FLAGS must be a JSON object of feature to true or false")
+	}
+	for name := range flags {
+		if !contains(knownFeatures, name) {
+			return nil, errors.Errorf("FEATURE_FLAGS has unknown feature %q, expected one of %v", name, strings.Join(knownFeatures, ", "))
+		}
+	}
+	return flags, nil
+}
+
+// enabled reports whether feature is on.
+func (f featureFlags) enabled(feature string) bool {
+	on, ok := f[feature]
+	return !ok || on
+}
+
+// disabledFeature returns the first feature cmd uses that is switched off, or
+// "" when every one is on.
+func (f featureFlags) disabledFeature(cmd command) string {
+	used := map[string]bool{
+		featureQR:     cmd.qr,
+		featureMulti:  len(cmd.groups) > 0,
+		featureRevoke: cmd.revoke,
+		featureAudit:  cmd.audit,
+		featureURL:    cmd.url != "",
+	}
+	for _, feature := range knownFeatures {
+		if used[feature] && !f.enabled(feature) {
+			return feature
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    string
+		text     string
+		disabled bool
+	}{
+		{name: "on by default", text: "demo qr"},
+		{name: "switched on", flags: `{"qr": true}`, text: "demo qr"},
+		{name: "switched off", flags: `{"qr": false}`, text: "demo qr", disabled: true},
+		{name: "other features unaffected", flags: `{"qr": false}`, text: "demo"},
+		{name: "revoke off", flags: `{"revoke": false}`, text: "revoke demo AbCd1234", disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, staticSource("TOKEN1234"), map[string]string{"FEATURE_FLAGS": tt.flags})
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm("U0001", tt.text)))
+
+			msg := decodeResponse(t, rec)
+			if disabled := msg.Text == english.text(msgFeatureDisabled); disabled != tt.disabled {
+				t.Errorf("reply = %q, want disabled %v", msg.Text, tt.disabled)
+			}
+		})
+	}
+}
+
+func TestParseFeatureFlagsUnknown(t *testing.T) {
+	_, err := parseFeatureFlags(`{"qrcode": false}`)
+	if err == nil || !strings.Contains(err.Error(), `unknown feature "qrcode"`) {
+		t.Errorf("parseFeatureFlags() = %v, want an unknown feature error", err)
+	}
+}
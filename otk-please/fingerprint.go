@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Fingerprint strategies, chosen with TOKEN_FINGERPRINT.
+const (
+	// fingerprintEdges keeps a few characters from each end of the token
+	fingerprintEdges = "edges"
+	// fingerprintHash keeps a prefix of the token's HMAC-SHA256 under
+	// TOKEN_FINGERPRINT_SALT
+	fingerprintHash = "hash"
+	// fingerprintNone records nothing about the token
+	fingerprintNone = "none"
+)
+
+// fingerprintHashLength is how many hex characters of the HMAC are kept.
+const fingerprintHashLength = 12
+
+// fingerprinter masks tokens so support can match one a user shares against
+// logs and audit records. A fingerprint never holds the full token.
+type fingerprinter struct {
+	strategy string
+	salt     string
+}
+
+// newFingerprinter checks strategy and returns its fingerprinter. An empty
+// strategy means fingerprintEdges.
+func newFingerprinter(strategy, salt string) (fingerprinter, error) {
+	switch strategy {
+	case "":
+		strategy = fingerprintEdges
+	case fingerprintEdges, fingerprintNone:
+	case fingerprintHash:
+		if salt == "" {
+			return fingerprinter{}, errors.New("TOKEN_FINGERPRINT_SALT is required when TOKEN_FINGERPRINT is hash")
+		}
+	default:
+		return fingerprinter{}, errors.Errorf("TOKEN_FINGERPRINT must be edges, hash or none, got %q", strategy)
+	}
+	return fingerprinter{strategy: strategy, salt: salt}, nil
+}
+
+// fingerprint masks token. The edges strategy keeps at most a quarter of the
+// token from each end, and no more than 4 characters, so short tokens stay
+// mostly hidden.
+func (f fingerprinter) fingerprint(token string) string {
+	switch f.strategy {
+	case fingerprintEdges:
+		n := len(token) / 4
+		if n > 4 {
+			n = 4
+		}
+		if n == 0 {
+			return "…"
+		}
+		return token[:n] + "…" + token[len(token)-n:]
+	case fingerprintHash:
+		mac := hmac.New(sha256.New, []byte(f.salt))
+		mac.Write([]byte(token))
+		return hex.EncodeToString(mac.Sum(nil))[:fingerprintHashLength]
+	default:
+		return ""
+	}
+}
+
+// fingerprints masks every token, or returns nil when fingerprinting is off.
+// The zero fingerprinter is off.
+func (f fingerprinter) fingerprints(tokens []Token) []string {
+	if f.strategy != fingerprintEdges && f.strategy != fingerprintHash {
+		return nil
+	}
+	masked := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		masked = append(masked, f.fingerprint(token.Value))
+	}
+	return masked
+}
+
+// joinFingerprints renders fingerprints for a log line or CSV cell.
+func joinFingerprints(fingerprints []string) string {
+	return strings.Join(fingerprints, " ")
+}
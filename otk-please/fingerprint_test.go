@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintNeverHoldsToken(t *testing.T) {
+	tokens := []string{"A", "ABC", "ABCDEFGH", "ABCDEFGHIJKLMNOP", strings.Repeat("0123456789", 6)}
+	for _, strategy := range []string{fingerprintEdges, fingerprintHash, fingerprintNone} {
+		f, err := newFingerprinter(strategy, "salt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range tokens {
+			masked := f.fingerprint(token)
+			if strings.Contains(masked, token) {
+				t.Errorf("%v fingerprint of %q is %q, which holds the token", strategy, token, masked)
+			}
+			if strategy == fingerprintEdges {
+				visible := len(strings.Replace(masked, "…", "", 1))
+				if visible > len(token)/2 || visible > 8 {
+					t.Errorf("edges fingerprint of %q shows %v characters: %q", token, visible, masked)
+				}
+			}
+		}
+	}
+}
+
+func TestMintLogsOnlyFingerprints(t *testing.T) {
+	var logs bytes.Buffer
+	previous := logger
+	logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() { logger = previous })
+
+	const token = "SECRET-TOKEN-0123456789"
+	a := testApp(t, staticSource(token), nil)
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	if !strings.Contains(rec.Body.String(), token) {
+		t.Fatalf("reply %q does not hold the token", rec.Body)
+	}
+	if strings.Contains(logs.String(), token) {
+		t.Errorf("logs hold the full token: %v", logs.String())
+	}
+	if want := a.config.Fingerprint.fingerprint(token); !strings.Contains(logs.String(), want) {
+		t.Errorf("logs do not hold the fingerprint %q", want)
+	}
+}
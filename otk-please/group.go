@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// group is one environment and count in a command naming several, e.g. the
+// "staging 3" in "demo 2 staging 3".
+type group struct {
+	environment string
+	count       int
+	// args holds a templated environment's slug
+	args []string
+}
+
+// parseGroups splits a command's words into groups when at least two of them
+// are environments. A count applies to the environment before it, and the
+// word after a templated environment is its slug. Any other word starts a
+// group of its own, so prepareGroups can reject it by name. It returns nil
+// for commands naming a single environment.
+func (a *app) parseGroups(sequence []string) []group {
+	var groups []group
+	var known int
+	for _, word := range sequence {
+		last := len(groups) - 1
+		switch {
+		case isNumber(word):
+			if last >= 0 {
+				groups[last].count, _ = strconv.Atoi(word)
+			}
+		case last >= 0 && len(groups[last].args) == 0 && a.templated(groups[last].environment):
+			groups[last].args = []string{word}
+		default:
+			groups = append(groups, group{environment: word, count: 1})
+			if _, matches := a.lookupEnvironment(word); len(matches) == 1 {
+				known++
+			}
+		}
+	}
+
+	if known < 2 {
+		return nil
+	}
+	return groups
+}
+
+// templated reports whether name selects an environment built from a slug.
+func (a *app) templated(name string) bool {
+	env, matches := a.lookupEnvironment(name)
+	return len(matches) == 1 && env.urlTemplate != ""
+}
+
+// prepareGroups runs every check for each group of cmd. The whole command is
+// rejected, naming the group, if any group fails; MaxTokens caps the total.
+func (a *app) prepareGroups(ctx context.Context, log *slog.Logger, s slack.SlashCommand, cmd command) (*mintRequest, response) {
+	var total int
+	for _, g := range cmd.groups {
+		total += g.count
+	}
+	if total > a.config.MaxTokens {
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgTooManyTokens, a.config.MaxTokens))
+	}
+
+	parent := &mintRequest{s: s, cmd: cmd, log: log}
+	var notes []string
+	for _, g := range cmd.groups {
+		groupCmd := cmd
+		groupCmd.environment, groupCmd.count, groupCmd.groups = g.environment, g.count, nil
+		groupCmd.args, groupCmd.rawArgs = g.args, g.args
+
+		m, msg := a.resolve(ctx, log, s, groupCmd)
+		switch {
+		case m == nil && msg.ErrorCode != "":
+			msg.Text = cmd.locale.text(msgGroupRejected, g.environment, msg.Text)
+			return nil, msg
+		case m == nil:
+			// Answered without minting, as dry runs are.
+			notes = append(notes, msg.Text)
+		default:
+			m.grouped = true
+			parent.groups = append(parent.groups, m)
+		}
+	}
+
+	if len(parent.groups) == 0 {
+		return nil, ephemeral(strings.Join(notes, "\n"))
+	}
+	parent.key, parent.env = parent.groups[0].key, parent.groups[0].env
+	return parent, response{}
+}
+
+// mintGroups mints each group in turn and combines the replies. A group that
+// fails doesn't stop the others; its failure is shown in place and its code
+// reported for the whole reply.
+func (a *app) mintGroups(ctx context.Context, m *mintRequest) response {
+	replies := make([]response, 0, len(m.groups))
+	var blocks bool
+	for _, g := range m.groups {
+		msg := a.mintReply(ctx, g)
+		replies = append(replies, msg)
+		blocks = blocks || len(msg.Blocks.BlockSet) > 0
+	}
+
+	var combined response
+	var texts []string
+	for _, msg := range replies {
+		texts = append(texts, msg.Text)
+		if combined.ErrorCode == "" {
+			combined.ErrorCode = msg.ErrorCode
+		}
+		if !blocks {
+			continue
+		}
+		if len(msg.Blocks.BlockSet) == 0 {
+			// Clients only show the blocks, so failures need one too.
+			text := slack.NewTextBlockObject(slack.MarkdownType, msg.Text, false, false)
+			combined.Blocks.BlockSet = append(combined.Blocks.BlockSet, slack.NewSectionBlock(text, nil, nil))
+			continue
+		}
+		combined.Blocks.BlockSet = append(combined.Blocks.BlockSet, msg.Blocks.BlockSet...)
+	}
+	combined.Text = strings.Join(texts, "\n")
+	if blocks {
+		combined.Blocks.BlockSet = append(combined.Blocks.BlockSet, mintAnotherBlock(m.cmd.locale, m.cmd.environment, m.s.Text))
+	}
+
+	combined.ResponseType = slack.ResponseTypeEphemeral
+	if m.cmd.public {
+		combined.ResponseType = slack.ResponseTypeInChannel
+	}
+	return combined
+}
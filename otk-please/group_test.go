@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGroupedMintAuditsEachEnvironment(t *testing.T) {
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		return Token{Value: strings.ToUpper(env.name) + "-TOKEN"}, nil
+	}), nil)
+	table := &fakeAuditTable{}
+	a.audit = &auditWriter{client: table, table: "audit"}
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo 2 staging 3")))
+
+	text := decodeResponse(t, rec).Text
+	if !strings.Contains(text, "DEMO-TOKEN") || !strings.Contains(text, "STAGING-TOKEN") {
+		t.Fatalf("reply %q is missing a group's tokens", text)
+	}
+
+	counts := map[string]int{}
+	ids := map[string]bool{}
+	for _, record := range table.records {
+		counts[record.Environment] += record.Count
+		ids[record.RequestID] = true
+	}
+	if len(table.records) != 2 || counts["Demo"] != 2 || counts["Staging"] != 3 {
+		t.Fatalf("audit records = %+v, want one for Demo with 2 and one for Staging with 3", table.records)
+	}
+	if len(ids) != 2 {
+		t.Errorf("audit records share a request_id, so one would overwrite the other: %+v", table.records)
+	}
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// mintWorkers bounds how many upstream requests run at once for a single
+// command.
+const mintWorkers = 4
+
+// ackTimeout is how long handler waits on a mint before falling back to
+// response_url, leaving headroom under Slack's 3 second limit.
+const ackTimeout = 2500 * time.Millisecond
+
+// app answers slash commands using its injected configuration and
+// dependencies.
+type app struct {
+	config  Config
+	source  TokenSource
+	secrets secretsProvider
+	audit   *auditWriter
+	// limiter is optional; nil disables rate limiting
+	limiter     rateLimiter
+	notifier    *notifier
+	idempotency *idempotencyStore
+	tokenCache  *tokenCache
+	// hook, when set, is told about every completed mint
+	hook MintHook
+}
+
+// MintHook observes mint outcomes, so integration tests can wait for the
+// async response path instead of sleeping. It is nil in production.
+// MintCompleted runs on the request path and must be fast and non-blocking,
+// e.g. a send on a buffered channel.
+type MintHook interface {
+	MintCompleted(environment string, err error)
+}
+
+// lookupEnvironment resolves the word a user typed against environment keys
+// and then aliases, returning the keys it matched. Exactly one match selects
+// env; an alias shared by several environments matches all of them.
+func (a *app) lookupEnvironment(name string) (env environment, matches []string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if env, ok := a.config.Environments[name]; ok {
+		return env, []string{name}
+	}
+
+	for key, candidate := range a.config.Environments {
+		if contains(candidate.aliases, name) {
+			env = candidate
+			matches = append(matches, key)
+		}
+	}
+	sort.Strings(matches)
+	return env, matches
+}
+
+// environmentKeys lists, sorted, the words that select an enabled
+// environment.
+func (a *app) environmentKeys() []string {
+	var keys []string
+	for key, env := range a.config.Environments {
+		if env.enabled() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseCommand parses text like the package-level parseCommand, then falls
+// back to the configured default environment when text names none. Only the
+// word "help" (or "?") reaches help once a default is set.
+//
+// "revoke [environment] <token>" and "audit <environment> [days]" are
+// rewritten to target the environment, with the token kept in its original
+// case.
+func (a *app) parseCommand(text string) (command, error) {
+	cmd, err := parseCommand(text)
+	if cmd.environment == "revoke" {
+		cmd.revoke = true
+		cmd.environment = ""
+		switch len(cmd.rawArgs) {
+		case 1:
+			cmd.token = cmd.rawArgs[0]
+		case 2:
+			cmd.environment = cmd.args[0]
+			cmd.token = cmd.rawArgs[1]
+		}
+		cmd.args, cmd.rawArgs = nil, nil
+	}
+	if cmd.environment == "audit" {
+		// "audit <environment> [days]": the number parses as a count.
+		cmd.audit = true
+		cmd.environment = cmd.arg()
+		cmd.days = defaultAuditDays
+		if cmd.hasCount {
+			cmd.days = cmd.count
+		}
+		cmd.count = 1
+		cmd.args, cmd.rawArgs = nil, nil
+	}
+	if !cmd.revoke && !cmd.audit && cmd.url == "" {
+		cmd.groups = a.parseGroups(cmd.sequence)
+	}
+	if cmd.environment == "" {
+		cmd.environment = a.config.DefaultEnvironment
+	}
+	return cmd, err
+}
+
+// helpText describes how to use slashCommand, e.g. "/please".
+func (a *app) helpText(l locale, slashCommand string) string {
+	keys := a.environmentKeys()
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("*%v*", key)
+		if a.config.Environments[key].maintenance {
+			keys[i] += " " + l.text(msgListDisabled)
+		}
+	}
+
+	lines := []string{
+		l.text(msgHelpUsage, slashCommand),
+		l.text(msgHelpEnvironment, strings.Join(keys, ", ")),
+	}
+	if a.config.DefaultEnvironment != "" {
+		lines = append(lines, l.text(msgHelpDefault, a.config.DefaultEnvironment))
+	}
+	lines = append(lines, l.text(msgHelpCount, a.config.MaxTokens))
+
+	// Features that are switched off aren't advertised.
+	for _, line := range []struct {
+		feature string
+		text    message
+	}{
+		{featureMulti, msgHelpGroups},
+		{"", msgHelpPublic},
+		{"", msgHelpLanguage},
+		{"", msgHelpDryRun},
+		{featureQR, msgHelpQR},
+		{"", msgHelpFormat},
+		{"", msgHelpVersion},
+		{"", msgHelpList},
+		{"", msgHelpWhoami},
+		{"", msgHelpErrors},
+		{"", msgHelpConfigCheck},
+		{featureRevoke, msgHelpRevoke},
+		{featureAudit, msgHelpAudit},
+		{featureURL, msgHelpURL},
+	} {
+		if line.feature == "" || a.config.Features.enabled(line.feature) {
+			lines = append(lines, l.text(line.text))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unknownEnvironment lists the environments usable from channelID, with
+// their aliases, for a command that named none of them.
+func (a *app) unknownEnvironment(l locale, channelID string) string {
+	var names []string
+	for _, key := range a.environmentKeys() {
+		env := a.config.Environments[key]
+		if !env.channelAllowed(channelID) {
+			continue
+		}
+		name := fmt.Sprintf("*%v*", key)
+		if len(env.aliases) > 0 {
+			name += fmt.Sprintf(" (%v)", strings.Join(env.aliases, ", "))
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return l.text(msgNoEnvironments)
+	}
+	return l.text(msgUnknownEnvironment, strings.Join(names, ", "))
+}
+
+// environmentList describes each enabled environment by key, aliases and
+// upstream host, marking sensitive ones. Secrets and full URLs are left out.
+func (a *app) environmentList(l locale) string {
+	lines := []string{l.text(msgListTitle)}
+	for _, key := range a.environmentKeys() {
+		env := a.config.Environments[key]
+		line := fmt.Sprintf("• *%v*", key)
+		if len(env.aliases) > 0 {
+			line += " " + l.text(msgListAliases, strings.Join(env.aliases, ", "))
+		}
+		if u, err := url.Parse(env.baseURL); err == nil && u.Host != "" {
+			line += fmt.Sprintf(" — %v", u.Host)
+		}
+		if env.sensitive {
+			line += " " + l.text(msgListSensitive)
+		}
+		if env.maintenance {
+			line += " " + l.text(msgListDisabled)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mintTokens calls the token source count times using a bounded pool of
+// workers. The first failure cancels the remaining requests and is returned
+// along with the tokens minted before it.
+func (a *app) mintTokens(ctx context.Context, env environment, count int) ([]Token, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		tokens   = make([]Token, count)
+		jobs     = make(chan int)
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	workers := mintWorkers
+	if count < workers {
+		workers = count
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				token, err := a.source.Mint(ctx, env)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				tokens[j] = token
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Keep every token that was minted, even when others failed: each one
+	// has already used up a one-time key.
+	minted := tokens[:0]
+	for _, token := range tokens {
+		if token.Value != "" {
+			minted = append(minted, token)
+		}
+	}
+	return minted, firstErr
+}
+
+// dryRun confirms the environment's bearer token is configured without
+// minting anything. Verification and environment resolution have already
+// passed by the time it runs.
+func (a *app) dryRun(ctx context.Context, log *slog.Logger, env environment, cmd command) string {
+	bearerToken, err := a.secrets.secret(ctx, env.secretName)
+	if err != nil {
+		log.Error("dry run could not load bearer token", "error", err)
+		return cmd.locale.text(msgNoCredentials, env.name)
+	}
+	if bearerToken == "" {
+		return cmd.locale.text(msgDryRunNoToken, env.name)
+	}
+
+	log.Info("dry run passed")
+	return cmd.locale.text(msgDryRunOK, env.name)
+}
+
+// mintRequest is a slash command that has passed every check and is ready
+// to mint.
+type mintRequest struct {
+	s   slack.SlashCommand
+	cmd command
+	// key is the canonical registry key cmd.environment resolved to
+	key string
+	env environment
+	log *slog.Logger
+	// groups are the mints of a command naming several environments, each
+	// answered as part of one reply
+	groups  []*mintRequest
+	grouped bool
+}
+
+// mintReply mints the requested tokens and renders the outcome as the
+// message to send back to Slack. Audit exports take the same slow path and
+// are answered here too.
+func (a *app) mintReply(ctx context.Context, m *mintRequest) response {
+	if m.cmd.audit {
+		return a.exportAudit(ctx, m)
+	}
+	if len(m.groups) > 0 {
+		return a.mintGroups(ctx, m)
+	}
+
+	s, cmd, env, log := m.s, m.cmd, m.env, m.log
+
+	if msg, ok := a.cachedReply(ctx, m); ok {
+		return msg
+	}
+
+	if a.config.Precheck {
+		if err := probeHealth(ctx, env); err != nil {
+			log.Warn("upstream failed its health check, not minting", "error", err)
+			recentErrors.record(env.name, codeUpstreamError)
+			return failure(codeUpstreamError, cmd.locale.text(msgUpstreamDown, env.name))
+		}
+	}
+
+	release, ok := inFlight.acquire(ctx, env)
+	if !ok {
+		log.Warn("too many concurrent mints")
+		recentErrors.record(env.name, codeRateLimited)
+		return failure(codeRateLimited, cmd.locale.text(msgTooConcurrent))
+	}
+	start := time.Now()
+	mintCtx, cancel := watchdogContext(ctx)
+	tokens, err := a.mintTokens(mintCtx, env, cmd.count)
+	abandoned := mintCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+	cancel()
+	latency := time.Since(start)
+	release()
+	emitTokenMetrics(env.name, err == nil, latency)
+	if a.hook != nil {
+		a.hook.MintCompleted(env.name, err)
+	}
+	log = log.With("upstream_status", upstreamStatus(err), "latency_ms", latency.Milliseconds())
+	if err != nil && len(tokens) == 0 {
+		log.Error("could not mint tokens", "error", err)
+		msg := mintFailure(cmd, env, err, abandoned)
+		recentErrors.record(env.name, msg.ErrorCode)
+		return msg
+	}
+	var partial response
+	if err != nil {
+		log.Error("could not mint every token", "minted", len(tokens), "requested", cmd.count, "error", err)
+		partial = mintFailure(cmd, env, err, abandoned)
+		recentErrors.record(env.name, partial.ErrorCode)
+	}
+
+	fingerprints := a.config.Fingerprint.fingerprints(tokens)
+	log.Info("minted tokens", "count", len(tokens), "fingerprints", joinFingerprints(fingerprints))
+
+	// Notify in the background while the audit record and QR codes are
+	// written, then wait for it below so Lambda doesn't freeze it mid-call.
+	notified := make(chan error, 1)
+	go func() {
+		notified <- a.notifier.sensitiveMint(ctx, s, env, len(tokens))
+	}()
+
+	// A grouped command mints several environments under one request, so
+	// each gets its own record rather than overwriting the last.
+	id := requestID(ctx)
+	if m.grouped {
+		id += "/" + env.name
+	}
+	err = a.audit.write(ctx, auditRecord{
+		RequestID:    id,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		UserID:       s.UserID,
+		UserName:     userName(s),
+		EnterpriseID: s.EnterpriseID,
+		ChannelID:    s.ChannelID,
+		Environment:  env.name,
+		Count:        len(tokens),
+		Fingerprints: fingerprints,
+	})
+	if err != nil {
+		log.Error("AUDIT FAILURE: could not record mint", "error", err)
+	}
+
+	if cmd.qr {
+		// QR codes follow the reply's visibility: the channel when public,
+		// otherwise a direct message to the user.
+		channel := s.UserID
+		if cmd.public {
+			channel = s.ChannelID
+		}
+		if err := uploadQRCodes(ctx, cmd.locale, env.name, tokens, channel); err != nil {
+			log.Warn("could not upload QR codes", "error", err)
+		}
+	}
+
+	if err := <-notified; err != nil {
+		log.Error("could not notify audit channel", "error", err)
+	}
+
+	if partial.ErrorCode == "" {
+		a.cacheTokens(ctx, m, tokens)
+	}
+	msg := a.deliverTokens(ctx, m, tokens)
+	if partial.ErrorCode != "" {
+		// Say how many are missing and why, below the tokens that were
+		// minted.
+		note := cmd.locale.text(msgPartialMint, cmd.count-len(tokens), cmd.count, partial.Text)
+		msg.Text += "\n" + note
+		if len(msg.Blocks.BlockSet) > 0 {
+			text := slack.NewTextBlockObject(slack.MarkdownType, note, false, false)
+			msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, slack.NewContextBlock("", text))
+		}
+		msg.ErrorCode = partial.ErrorCode
+	}
+	return msg
+}
+
+// mintFailure explains why minting for cmd failed with err. abandoned means
+// the watchdog gave up on the upstream.
+func mintFailure(cmd command, env environment, err error, abandoned bool) response {
+	if abandoned {
+		// The watchdog gave up so the reply is sent before Lambda kills the
+		// invocation.
+		return failure(codeTimeout, cmd.locale.text(msgTookTooLong))
+	}
+	var credErr *credentialsError
+	if errors.As(err, &credErr) {
+		return failure(codeInternalError, cmd.locale.text(msgNoCredentials, env.name))
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return failure(codeUpstreamError, cmd.locale.text(msgUnavailable))
+	}
+	if errors.Is(err, errMalformedToken) {
+		emitMalformedToken(env.name)
+		return failure(codeUpstreamError, cmd.locale.text(msgMalformedToken, env.name))
+	}
+	if errors.Is(err, errUpstreamBusy) {
+		return failure(codeRateLimited, cmd.locale.text(msgBusy))
+	}
+	if isCertificateError(err) {
+		return failure(codeUpstreamError, cmd.locale.text(msgCertificateError, env.name))
+	}
+	if isTimeout(err) {
+		return failure(codeTimeout, cmd.locale.text(msgTimeout, env.name))
+	}
+	if status := upstreamStatus(err); status != 0 {
+		return failure(codeUpstreamError, cmd.locale.text(msgUpstreamStatus, env.name, status))
+	}
+	return failure(codeUpstreamError, cmd.locale.text(msgUpstreamFailed, env.name))
+}
+
+// tokenReply renders tokens minted for m in the format it asked for.
+func (a *app) tokenReply(m *mintRequest, tokens []Token) response {
+	s, cmd, env, log := m.s, m.cmd, m.env, m.log
+
+	if env.wrapper != nil {
+		for i := range tokens {
+			wrapped, err := wrapToken(env.wrapper, tokens[i].Value)
+			if err != nil {
+				log.Error("could not render token wrapper", "error", err)
+				break
+			}
+			tokens[i].Wrapped = wrapped
+		}
+	}
+
+	msg := response{Msg: slack.Msg{Text: formatTokens(cmd.locale, env.label(), userLabel(s), tokens)}}
+	switch {
+	case cmd.json:
+		msg.Text = formatJSON(m.key, tokens)
+	case env.reply != nil:
+		text, err := renderReply(env.reply, cmd.locale, env.name, userLabel(s), tokens)
+		if err != nil {
+			log.Error("could not render reply template", "error", err)
+			break
+		}
+		msg.Text = text
+	case !a.config.PlainText:
+		msg.Msg = buildTokenBlocks(cmd.locale, env.label(), userLabel(s), tokens...)
+		if !m.grouped {
+			msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, mintAnotherBlock(cmd.locale, cmd.environment, s.Text))
+		}
+	}
+
+	msg.ResponseType = slack.ResponseTypeEphemeral
+	if cmd.public {
+		msg.ResponseType = slack.ResponseTypeInChannel
+	}
+	return msg
+}
+
+// prepare parses s and runs every check that comes before minting. It returns
+// the mint to run, or nil and the reply when the command is answered without
+// minting: help, version, dry runs and rejections.
+func (a *app) prepare(ctx context.Context, log *slog.Logger, s slack.SlashCommand) (*mintRequest, response) {
+	// Bound the text before any parsing. Its locale isn't known yet.
+	if len(s.Text) > a.config.MaxInputLength {
+		log.Warn("command text is too long", "length", len(s.Text))
+		return nil, failure(codeInvalidCommand, english.text(msgInputTooLong))
+	}
+
+	cmd, err := a.parseCommand(s.Text)
+
+	route := a.config.Commands.route(s.Command)
+	if route == "" {
+		log.Warn("slash command is not routed", "command", s.Command)
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgUnknownCommand, s.Command))
+	}
+
+	if cmd.isWhoami() {
+		return nil, ephemeral(a.whoami(cmd.locale, s))
+	}
+
+	if !a.config.userAllowed(s.UserID) || !a.config.enterpriseAllowed(s.EnterpriseID) {
+		log.Warn("user is not authorized")
+		return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+	}
+
+	if route == routeList {
+		return nil, ephemeral(a.environmentList(cmd.locale))
+	}
+
+	if err != nil {
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgInvalidCount))
+	}
+
+	if feature := a.config.Features.disabledFeature(cmd); feature != "" {
+		log.Info("feature is not enabled", "feature", feature)
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgFeatureDisabled))
+	}
+
+	if cmd.revoke && (cmd.token == "" || cmd.environment == "") {
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgRevokeUsage))
+	}
+
+	// Audit exports are checked against the environment's admins once it is
+	// resolved; custom URLs aren't in any environment, so need a global admin.
+	if cmd.url != "" && !a.config.isAdmin(s.UserID) {
+		log.Warn("user is not an admin")
+		return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+	}
+
+	if cmd.isConfigCheck() {
+		if !a.config.isAdmin(s.UserID) {
+			log.Warn("user is not an admin")
+			return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+		}
+		return nil, ephemeral(a.configCheck(ctx, cmd.locale))
+	}
+
+	if cmd.isErrors() {
+		if !a.config.isAdmin(s.UserID) {
+			log.Warn("user is not an admin")
+			return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+		}
+		n := defaultRecentErrors
+		if cmd.hasCount {
+			n = cmd.count
+		}
+		return nil, ephemeral(recentErrorsText(cmd.locale, n))
+	}
+
+	if cmd.url != "" && !cmd.audit && !cmd.revoke {
+		env, err := customEnvironment(cmd.url, a.config.AdminSecretName)
+		if err != nil {
+			log.Warn("custom URL is not allowed", "error", err)
+			return nil, failure(codeInvalidCommand, cmd.locale.text(msgInvalidURL))
+		}
+		log.Warn("minting against a custom URL", "url", env.tokenURL())
+		return a.prepareMint(ctx, log.With("environment", env.name), s, cmd, "custom", env)
+	}
+
+	if cmd.isHelp() {
+		return nil, ephemeral(a.helpText(cmd.locale, s.Command))
+	}
+
+	if cmd.isVersion() {
+		return nil, ephemeral(cmd.locale.text(msgVersion, version, commit, buildTime))
+	}
+
+	if cmd.isList() {
+		return nil, ephemeral(a.environmentList(cmd.locale))
+	}
+
+	if len(cmd.groups) > 0 {
+		return a.prepareGroups(ctx, log, s, cmd)
+	}
+	return a.resolve(ctx, log, s, cmd)
+}
+
+// resolve looks up cmd's environment and runs the checks that depend on it.
+func (a *app) resolve(ctx context.Context, log *slog.Logger, s slack.SlashCommand, cmd command) (*mintRequest, response) {
+	env, matches := a.lookupEnvironment(cmd.environment)
+	switch {
+	case len(matches) == 0:
+		if sub := suggestSubcommand(cmd.environment); sub != "" {
+			return nil, failure(codeInvalidCommand, cmd.locale.text(msgDidYouMean, sub))
+		}
+		return nil, failure(codeUnknownEnv, a.unknownEnvironment(cmd.locale, s.ChannelID))
+	case len(matches) > 1:
+		for i, key := range matches {
+			matches[i] = fmt.Sprintf("*%v*", key)
+		}
+		return nil, failure(codeUnknownEnv, cmd.locale.text(msgAmbiguous, cmd.environment, strings.Join(matches, ", ")))
+	}
+
+	log = log.With("environment", env.name)
+
+	if !env.enabled() {
+		return nil, failure(codeUnknownEnv, cmd.locale.text(msgNotEnabled, env.name))
+	}
+
+	if env.maintenance {
+		log.Info("environment is in maintenance")
+		return nil, failure(codeUpstreamError, cmd.locale.text(msgMaintenance, env.name))
+	}
+
+	if !a.config.envAllowed(env, s.UserID) {
+		log.Warn("user is not authorized for environment")
+		return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+	}
+
+	if env.urlTemplate != "" {
+		var err error
+		env, err = env.withSlug(cmd.arg())
+		if err != nil {
+			return nil, failure(codeInvalidCommand, cmd.locale.text(msgInvalidSlug))
+		}
+		log = log.With("environment", env.name)
+	}
+
+	if cmd.audit {
+		if !a.config.envAdmin(env, s.UserID) {
+			log.Warn("user is not an admin of the environment")
+			return nil, failure(codeUnauthorized, cmd.locale.text(msgNotAuthorized))
+		}
+		// Exports go to the admin directly, so channel limits don't apply.
+		return &mintRequest{s: s, cmd: cmd, key: matches[0], env: env, log: log}, response{}
+	}
+
+	return a.prepareMint(ctx, log, s, cmd, matches[0], env)
+}
+
+// prepareMint runs the checks that come after the environment is resolved,
+// for registry environments and admins' custom URLs alike.
+func (a *app) prepareMint(ctx context.Context, log *slog.Logger, s slack.SlashCommand, cmd command, key string, env environment) (*mintRequest, response) {
+	if !env.channelAllowed(s.ChannelID) {
+		log.Warn("channel is not allowed for environment")
+		return nil, failure(codeUnauthorized, cmd.locale.text(msgChannelNotAllowed, env.name, formatChannels(env.allowedChannels)))
+	}
+
+	if cmd.count > a.config.MaxTokens {
+		return nil, failure(codeInvalidCommand, cmd.locale.text(msgTooManyTokens, a.config.MaxTokens))
+	}
+
+	if cmd.dryRun {
+		return nil, ephemeral(a.dryRun(ctx, log, env, cmd))
+	}
+
+	bearerToken, err := a.secrets.secret(ctx, env.secretName)
+	if err != nil {
+		log.Error("could not load bearer token", "error", err)
+		return nil, failure(codeInternalError, cmd.locale.text(msgNoCredentials, env.name))
+	}
+	if bearerToken == "" {
+		log.Error("bearer token is not configured", "secret", env.secretName)
+		return nil, failure(codeInternalError, cmd.locale.text(msgNotConfigured))
+	}
+
+	if cmd.revoke {
+		return nil, a.revoke(ctx, log, env, cmd, bearerToken)
+	}
+
+	return &mintRequest{s: s, cmd: cmd, key: key, env: env, log: log}, response{}
+}
+
+// rateLimited records m's mints against its user's rate limit, returning the
+// reply when the limit has been reached. It runs after the idempotency claim
+// so Slack's retries of a command aren't counted again. A command naming
+// several environments counts every group.
+func (a *app) rateLimited(ctx context.Context, m *mintRequest) (response, bool) {
+	if a.limiter == nil || m.cmd.audit {
+		return response{}, false
+	}
+
+	count := m.cmd.count
+	if len(m.groups) > 0 {
+		count = 0
+		for _, g := range m.groups {
+			count += g.cmd.count
+		}
+	}
+
+	wait, err := a.limiter.allow(ctx, m.s.UserID, count)
+	var over *overLimitError
+	if errors.As(err, &over) {
+		m.log.Warn("mint is larger than the rate limit", "count", count)
+		return failure(codeRateLimited, m.cmd.locale.text(msgTooManyTokens, over.max)), true
+	}
+	if err != nil {
+		// Fail open: a broken limiter table shouldn't block minting.
+		m.log.Error("could not check rate limit", "error", err)
+		return response{}, false
+	}
+	if wait > 0 {
+		m.log.Warn("rate limit reached")
+		return failure(codeRateLimited, m.cmd.locale.text(msgRateLimited, int(wait.Seconds()+1))), true
+	}
+	return response{}, false
+}
+
+// handler answers a slash command. Mints that finish within ackTimeout are
+// returned inline. Slower ones are delivered to the command's response_url and
+// the HTTP reply becomes a "Working on it..." acknowledgement.
+//
+// Lambda freezes the process as soon as the handler returns and the proxy
+// adapter only sends the response at that point, so a true fire-and-forget
+// goroutine would never finish. Instead the handler waits for the mint and
+// posts it to response_url before returning the acknowledgement. Slack may
+// already have given up on the acknowledgement by then, but the token still
+// reaches the user through response_url rather than being lost.
+func (a *app) handler(w http.ResponseWriter, req *http.Request) {
+	defer flush(req.Context())
+	log := logger.With("request_id", requestID(req.Context()))
+
+	start := time.Now()
+	w = &timedWriter{ResponseWriter: w, start: start}
+	defer func() {
+		log.Info("handled request", "duration_ms", time.Since(start).Milliseconds())
+	}()
+
+	if answerSSLCheck(w, req) {
+		log.Info("answered ssl check")
+		return
+	}
+
+	err := trace(req.Context(), "verify", func(ctx context.Context) error {
+		return verifyRequest(req, a.signingSecret, a.config.ReplayWindow, int64(a.config.MaxBodyBytes))
+	})
+	if err != nil {
+		log.Warn("request verification failed", "error", err)
+		verificationFailed(w, err)
+		return
+	}
+
+	if answerURLVerification(w, req) {
+		log.Info("answered url verification")
+		return
+	}
+
+	s, err := slack.SlashCommandParse(req)
+	if err != nil {
+		// Slack sent something we can't read, as opposed to our failing to
+		// handle a valid command.
+		log.Error("could not parse slash command", "error", err)
+		writeError(w, http.StatusBadRequest, codeInvalidCommand, "could not parse slash command")
+		return
+	}
+
+	log = log.With("user_id", s.UserID, "user_name", userName(s), "channel_id", s.ChannelID, "enterprise_id", s.EnterpriseID)
+	// Slack sends thread_ts when the command is run inside a thread.
+	thread := req.PostFormValue("thread_ts")
+
+	m, msg := a.prepare(req.Context(), log, s)
+	if m == nil {
+		respond(w, msg.inThread(thread))
+		return
+	}
+
+	if retry := req.Header.Get("X-Slack-Retry-Num"); retry != "" {
+		m.log = m.log.With("retry_num", retry)
+	}
+	first, err := a.idempotency.claim(req.Context(), commandKey(s.TeamID, s.TriggerID))
+	if err != nil {
+		// Fail open like the rate limiter: a broken table shouldn't block
+		// minting.
+		m.log.Error("could not check idempotency", "error", err)
+	} else if !first {
+		m.log.Warn("command was already processed")
+		respond(w, ephemeral(m.cmd.locale.text(msgAlreadyProcessed)).inThread(thread))
+		return
+	}
+
+	if msg, limited := a.rateLimited(req.Context(), m); limited {
+		respond(w, msg.inThread(thread))
+		return
+	}
+
+	result := make(chan response, 1)
+	go func() {
+		result <- a.mintReply(req.Context(), m)
+	}()
+
+	select {
+	case msg := <-result:
+		respond(w, msg.inThread(thread))
+	case <-time.After(ackTimeout):
+		msg := <-result
+		if err := postResponse(req.Context(), s.ResponseURL, msg.inThread(thread)); err != nil {
+			m.log.Error("could not post delayed response", "error", err)
+			emitDeliveryFailure(m.env.name)
+		}
+		respond(w, ephemeral(m.cmd.locale.text(msgWorking)).inThread(thread))
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// stubSource mints with a func, so tests choose each token or failure.
+type stubSource func(ctx context.Context, env environment) (Token, error)
+
+func (s stubSource) Mint(ctx context.Context, env environment) (Token, error) {
+	return s(ctx, env)
+}
+
+// staticSource mints value for every environment.
+func staticSource(value string) stubSource {
+	return func(ctx context.Context, env environment) (Token, error) {
+		return Token{Value: value}, nil
+	}
+}
+
+// testApp builds an app from the environment variables in env, the way setup
+// does, with bearer tokens read from the environment and minting with
+// source.
+func testApp(t *testing.T, source TokenSource, env map[string]string) *app {
+	t.Helper()
+	t.Setenv("SLACK_SIGNING_SECRET", testSigningSecret)
+	t.Setenv("SECRETS_BACKEND", "env")
+	t.Setenv("DEMO", "demo-bearer")
+	t.Setenv("STAGING", "staging-bearer")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreUpstream(t)
+	configureUpstream(config)
+	return &app{config: config, source: source, secrets: envSecrets{}}
+}
+
+// slashForm is the form Slack posts for text typed by userID.
+func slashForm(userID, text string) url.Values {
+	return url.Values{
+		"command":      {"/please"},
+		"text":         {text},
+		"team_id":      {"T0001"},
+		"channel_id":   {"C0001"},
+		"user_id":      {userID},
+		"user_name":    {"alice"},
+		"trigger_id":   {"13345224609.738474920.8088930838d88f008e0"},
+		"response_url": {"https://hooks.slack.com/commands/1234/5678"},
+	}
+}
+
+// slackSignature signs body at timestamp the way Slack does.
+func slackSignature(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRequest posts body to path, signed with secret at timestamp.
+func signedRequest(path, secret string, timestamp time.Time, body string) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", slackSignature(secret, ts, body))
+	return req
+}
+
+// slashRequest is a slash command with form, signed now with the test secret.
+func slashRequest(form url.Values) *http.Request {
+	return signedRequest("/", testSigningSecret, time.Now(), form.Encode())
+}
+
+// decodeResponse reads the reply handler sent.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) response {
+	t.Helper()
+	var msg response
+	if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("could not decode %q: %v", rec.Body.String(), err)
+	}
+	return msg
+}
+
+func TestHandler(t *testing.T) {
+	source := stubSource(func(ctx context.Context, env environment) (Token, error) {
+		return Token{Value: strings.ToUpper(env.name) + "-TOKEN"}, nil
+	})
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		secret   string
+		userID   string
+		text     string
+		status   int
+		code     errorCode
+		contains string
+	}{
+		{name: "demo", text: "demo", status: http.StatusOK, contains: "DEMO-TOKEN"},
+		{name: "staging", text: "staging", status: http.StatusOK, contains: "STAGING-TOKEN"},
+		{name: "alias", text: "stg", status: http.StatusOK, contains: "STAGING-TOKEN"},
+		{name: "unknown environment", text: "nowhere", status: http.StatusOK, code: codeUnknownEnv},
+		{name: "bad signature", secret: "not-the-secret", text: "demo", status: http.StatusUnauthorized, code: codeUnauthorized},
+		{
+			name:   "unauthorized user",
+			env:    map[string]string{"ALLOWED_USERS": "U0002"},
+			text:   "demo",
+			status: http.StatusOK,
+			code:   codeUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, source, tt.env)
+			secret := tt.secret
+			if secret == "" {
+				secret = testSigningSecret
+			}
+			userID := tt.userID
+			if userID == "" {
+				userID = "U0001"
+			}
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, signedRequest("/", secret, time.Now(), slashForm(userID, tt.text).Encode()))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %v", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get(errorCodeHeader); got != string(tt.code) {
+				t.Errorf("%v = %q, want %q", errorCodeHeader, got, tt.code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("body %q does not contain %q", rec.Body, tt.contains)
+			}
+		})
+	}
+}
+
+func TestHandlerDoesNotMintOnRejection(t *testing.T) {
+	minted := false
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		minted = true
+		return Token{Value: "TOKEN"}, nil
+	}), map[string]string{"ALLOWED_USERS": "U0002"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	if minted {
+		t.Error("minted a token for a user who is not allowed")
+	}
+}
+
+func TestMalformedFormIsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "slash command", path: "/", body: "command=%2Fplease&text=%zz"},
+		{name: "interaction payload", path: "/interactions", body: "payload=%7Bnot-json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, staticSource("TOKEN1234"), nil)
+			req := signedRequest(tt.path, testSigningSecret, time.Now(), tt.body)
+
+			rec := httptest.NewRecorder()
+			if tt.path == "/interactions" {
+				a.interactionHandler(rec, req)
+			} else {
+				a.handler(rec, req)
+			}
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %v, want 400: %v", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var doc struct {
+				Error     string    `json:"error"`
+				ErrorCode errorCode `json:"error_code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+			}
+			if doc.ErrorCode != codeInvalidCommand || doc.Error == "" {
+				t.Errorf("body = %+v, want an %v error", doc, codeInvalidCommand)
+			}
+		})
+	}
+}
+
+func TestHandlerWatchdog(t *testing.T) {
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		<-ctx.Done()
+		return Token{}, ctx.Err()
+	}), nil)
+
+	// The watchdog gives up watchdogMargin before the deadline, leaving time
+	// to reply.
+	ctx, cancel := context.WithTimeout(context.Background(), watchdogMargin+200*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")).WithContext(ctx))
+
+	if ctx.Err() != nil {
+		t.Fatal("the reply was sent after the deadline")
+	}
+	msg := decodeResponse(t, rec)
+	if msg.ErrorCode != codeTimeout || msg.Text != english.text(msgTookTooLong) {
+		t.Errorf("reply = %q (%v), want the took too long message", msg.Text, msg.ErrorCode)
+	}
+}
+
+func TestPartialMint(t *testing.T) {
+	// The third mint fails, whichever worker makes it.
+	var calls int32
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 3 {
+			return Token{}, &upstreamError{statusCode: http.StatusBadGateway}
+		}
+		return Token{Value: "TOKEN-" + strconv.Itoa(int(n)) + "-ABCD"}, nil
+	}), map[string]string{"DEMO_CACHE_SECONDS": "60", "PLAIN_TEXT": "true"})
+	cache, table := testCache(t)
+	a.tokenCache = cache
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo 3")))
+
+	msg := decodeResponse(t, rec)
+	for _, token := range []string{"TOKEN-1-ABCD", "TOKEN-2-ABCD"} {
+		if !strings.Contains(msg.Text, token) {
+			t.Errorf("reply %q is missing %v", msg.Text, token)
+		}
+	}
+	note := english.text(msgPartialMint, 1, 3, english.text(msgUpstreamStatus, "Demo", http.StatusBadGateway))
+	if !strings.Contains(msg.Text, note) {
+		t.Errorf("reply %q does not contain the partial note %q", msg.Text, note)
+	}
+	if msg.ErrorCode != codeUpstreamError {
+		t.Errorf("error_code = %v, want %v", msg.ErrorCode, codeUpstreamError)
+	}
+	if len(table.items) != 0 {
+		t.Error("cached the tokens of a partial mint")
+	}
+}
+
+// mintOutcome is one MintCompleted call.
+type mintOutcome struct {
+	environment string
+	err         error
+}
+
+// chanHook sends every mint outcome on a buffered channel.
+type chanHook chan mintOutcome
+
+func (h chanHook) MintCompleted(environment string, err error) {
+	h <- mintOutcome{environment: environment, err: err}
+}
+
+func TestMintHookOnDelayedResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		contains string
+		code     errorCode
+	}{
+		{name: "success", contains: "TOKEN1234"},
+		{name: "failure", err: errors.New("connection reset"), contains: "Demo", code: codeUpstreamError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+				// Outlast ackTimeout so the reply goes to response_url.
+				select {
+				case <-time.After(ackTimeout + 200*time.Millisecond):
+				case <-ctx.Done():
+					return Token{}, ctx.Err()
+				}
+				if tt.err != nil {
+					return Token{}, tt.err
+				}
+				return Token{Value: "TOKEN1234"}, nil
+			}), nil)
+			hook := make(chanHook, 1)
+			a.hook = hook
+
+			posted := make(chan response, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var msg response
+				json.NewDecoder(req.Body).Decode(&msg)
+				posted <- msg
+			}))
+			defer srv.Close()
+
+			form := slashForm("U0001", "demo")
+			form.Set("response_url", srv.URL)
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				a.handler(rec, slashRequest(form))
+			}()
+
+			select {
+			case outcome := <-hook:
+				if outcome.environment != "Demo" || !errors.Is(outcome.err, tt.err) {
+					t.Errorf("MintCompleted(%q, %v), want (%q, %v)", outcome.environment, outcome.err, "Demo", tt.err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("MintCompleted was never called")
+			}
+
+			select {
+			case msg := <-posted:
+				if !strings.Contains(msg.Text, tt.contains) || msg.ErrorCode != tt.code {
+					t.Errorf("response_url got %q with code %q, want %q with code %q", msg.Text, msg.ErrorCode, tt.contains, tt.code)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("nothing was posted to response_url")
+			}
+
+			<-done
+			if got := decodeResponse(t, rec).Text; got != english.text(msgWorking) {
+				t.Errorf("immediate reply = %q, want the working message", got)
+			}
+		})
+	}
+}
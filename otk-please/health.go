@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type healthResponse struct {
+	Version      string   `json:"version"`
+	Commit       string   `json:"commit"`
+	BuildTime    string   `json:"build_time"`
+	Environments []string `json:"environments"`
+}
+
+// healthHandler reports that the function is alive for uptime monitoring. It
+// skips Slack signature verification, so it must only ever expose
+// non-sensitive information: no tokens, secrets or upstream URLs.
+func (a *app) healthHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := json.Marshal(healthResponse{
+		Version:      version,
+		Commit:       commit,
+		BuildTime:    buildTime,
+		Environments: a.environmentKeys(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// idempotencyTTL is how long a processed command is remembered. Slack gives
+// up retrying long before this.
+const idempotencyTTL = 15 * time.Minute
+
+// idempotencyStore remembers which slash commands have already minted, in the
+// DynamoDB table named by IDEMPOTENCY_TABLE, so Slack's retries (sent with
+// X-Slack-Retry-Num) don't mint a second set of one-time keys. Expired items
+// are cleaned up by the table's TTL on expires_at. A nil *idempotencyStore
+// treats every command as new.
+type idempotencyStore struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+}
+
+func newIdempotencyStore(sess *session.Session) *idempotencyStore {
+	table := os.Getenv("IDEMPOTENCY_TABLE")
+	if table == "" {
+		return nil
+	}
+	return &idempotencyStore{client: dynamodb.New(sess), table: table}
+}
+
+// commandKey identifies one user action. A retry is signed afresh with a new
+// timestamp, so the signature can't be used, but Slack resends the same
+// trigger_id.
+func commandKey(teamID, triggerID string) string {
+	return teamID + ":" + triggerID
+}
+
+// claim records key and reports whether this is the first time it was seen.
+func (s *idempotencyStore) claim(ctx context.Context, key string) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	_, err := s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"command_key": {S: aws.String(key)},
+			"expires_at":  number(time.Now().Add(idempotencyTTL).Unix()),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(command_key)"),
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "PutItem failed")
+	}
+	return true, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeIdempotencyTable implements the conditional put claim uses.
+type fakeIdempotencyTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (f *fakeIdempotencyTable) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := aws.StringValue(in.Item["command_key"].S)
+	if f.keys[key] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "exists", nil)
+	}
+	f.keys[key] = true
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// countingLimiter allows everything and counts what it was asked to record.
+type countingLimiter struct {
+	mu    sync.Mutex
+	mints int
+}
+
+func (l *countingLimiter) allow(ctx context.Context, userID string, n int) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mints += n
+	return 0, nil
+}
+
+func TestRetriedCommandMintsOnce(t *testing.T) {
+	var mints int32
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		atomic.AddInt32(&mints, 1)
+		return Token{Value: "TOKEN1234"}, nil
+	}), nil)
+	a.idempotency = &idempotencyStore{client: &fakeIdempotencyTable{keys: map[string]bool{}}, table: "idempotency"}
+	limiter := &countingLimiter{}
+	a.limiter = limiter
+
+	form := slashForm("U0001", "demo 2")
+	a.handler(httptest.NewRecorder(), slashRequest(form))
+
+	retry := slashRequest(form)
+	retry.Header.Set("X-Slack-Retry-Num", "1")
+	rec := httptest.NewRecorder()
+	a.handler(rec, retry)
+
+	if msg := decodeResponse(t, rec); msg.Text != english.text(msgAlreadyProcessed) {
+		t.Errorf("retry reply = %q, want the already processed message", msg.Text)
+	}
+	if got := atomic.LoadInt32(&mints); got != 2 {
+		t.Errorf("minted %v tokens, want 2", got)
+	}
+	if limiter.mints != 2 {
+		t.Errorf("rate limiter counted %v mints, want 2", limiter.mints)
+	}
+}
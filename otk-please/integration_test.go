@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSlashCommandMintsFromUpstream runs a signed slash command through the
+// handler and the real httpSource against a mock submission server.
+func TestSlashCommandMintsFromUpstream(t *testing.T) {
+	var authorization, path string
+	upstream := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		path = req.URL.Path
+		w.Write([]byte("UPSTREAM-TOKEN-1234\n"))
+	})
+	a := testApp(t, httpSource{secrets: envSecrets{}}, map[string]string{"DEMO_URL": upstream.baseURL})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v, want 200: %v", rec.Code, rec.Body)
+	}
+	if msg := decodeResponse(t, rec); !strings.Contains(msg.Text, "UPSTREAM-TOKEN-1234") {
+		t.Errorf("reply %q does not contain the minted token", msg.Text)
+	}
+	if path != defaultTokenPath {
+		t.Errorf("upstream path = %q, want %q", path, defaultTokenPath)
+	}
+	if authorization != "Bearer demo-bearer" {
+		t.Errorf("Authorization = %q, want the demo bearer token", authorization)
+	}
+}
+
+func TestUntrustedCertificate(t *testing.T) {
+	var requests int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Write([]byte("TOKEN1234"))
+	}))
+	defer srv.Close()
+	// The upstream client doesn't trust the test server's certificate.
+	testUpstreamClient(t)
+	a := testApp(t, httpSource{secrets: envSecrets{}}, map[string]string{"DEMO_URL": srv.URL, "MAX_RETRIES": "3"})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+	msg := decodeResponse(t, rec)
+	if msg.Text != english.text(msgCertificateError, "Demo") || msg.ErrorCode != codeUpstreamError {
+		t.Errorf("reply = %q (%v), want the certificate message", msg.Text, msg.ErrorCode)
+	}
+	if requests != 0 {
+		t.Errorf("the server answered %v requests over an untrusted connection", requests)
+	}
+}
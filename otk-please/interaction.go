@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// interactionHandler answers Block Kit button presses. Slack signs
+// interaction payloads the same way as slash commands, so they are verified
+// before anything is parsed.
+//
+// A "Mint another" press replays the original command text as the pressing
+// user, so it goes through the same checks as a typed command, and replaces
+// the original message through the payload's response_url.
+func (a *app) interactionHandler(w http.ResponseWriter, req *http.Request) {
+	defer flush(req.Context())
+	log := logger.With("request_id", requestID(req.Context()))
+
+	err := trace(req.Context(), "verify", func(ctx context.Context) error {
+		return verifyRequest(req, a.signingSecret, a.config.ReplayWindow, int64(a.config.MaxBodyBytes))
+	})
+	if err != nil {
+		log.Warn("interaction verification failed", "error", err)
+		verificationFailed(w, err)
+		return
+	}
+
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(req.PostFormValue("payload")), &callback); err != nil {
+		log.Error("could not parse interaction payload", "error", err)
+		writeError(w, http.StatusBadRequest, codeInvalidCommand, "could not parse interaction payload")
+		return
+	}
+
+	enterpriseID, _ := payloadWorkspace(req.PostFormValue("payload"))
+	log = log.With("user_id", callback.User.ID, "user_name", callback.User.Name, "channel_id", callback.Channel.ID)
+
+	if callback.Type != slack.InteractionTypeBlockActions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		if !strings.HasPrefix(action.ActionID, mintAnotherAction) {
+			continue
+		}
+
+		// The button's value is the command text; ignore presses whose
+		// action_id names a different environment than the text does.
+		cmd, _ := a.parseCommand(action.Value)
+		if cmd.environment != strings.TrimPrefix(action.ActionID, mintAnotherAction) {
+			log.Warn("mint another action does not match its command", "action_id", action.ActionID)
+			continue
+		}
+
+		s := slack.SlashCommand{
+			TeamID:       callback.Team.ID,
+			EnterpriseID: enterpriseID,
+			ChannelID:    callback.Channel.ID,
+			UserID:       callback.User.ID,
+			UserName:     callback.User.Name,
+			Text:         action.Value,
+			ResponseURL:  callback.ResponseURL,
+		}
+
+		var environment string
+		m, msg := a.prepare(req.Context(), log, s)
+		if m != nil {
+			environment = m.env.name
+			if limited, ok := a.rateLimited(req.Context(), m); ok {
+				msg = limited
+			} else {
+				msg = a.mintReply(req.Context(), m)
+			}
+		}
+
+		// Keep the replacement in the thread the original message was in.
+		msg = msg.inThread(callback.Message.ThreadTimestamp)
+		msg.ReplaceOriginal = true
+		if err := postResponse(req.Context(), callback.ResponseURL, msg); err != nil {
+			log.Error("could not post interaction response", "environment", environment, "error", err)
+			emitDeliveryFailure(environment)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
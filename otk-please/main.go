@@ -1,20 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io/ioutil"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"os"
-	"regexp"
-	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	"github.com/awslabs/aws-lambda-go-api-proxy/handlerfunc"
-	"github.com/pkg/errors"
-	"github.com/slack-go/slack"
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -23,117 +20,132 @@ import (
 // https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
 type Response events.APIGatewayProxyResponse
 
-const (
-	demoAddress    string = "https://submission.covid-alert-demo.cdssandbox.xyz/new-key-claim"
-	stagingAddress string = "https://submission.wild-samphire.cdssandbox.xyz/new-key-claim"
-)
+// logLevel is the minimum level logged, info until the Config's LogLevel is
+// applied at startup.
+var logLevel = new(slog.LevelVar)
 
-func verifyRequest(req *http.Request) error {
-	secretVerifier, err := slack.NewSecretsVerifier(req.Header, os.Getenv("SLACK_SIGNING_SECRET"))
-	if err != nil {
-		return errors.Wrap(err, "NewSecretsVerifier failed")
-	}
+// logger writes JSON lines to stdout so CloudWatch Insights can query them.
+// Never log token values or bearer tokens.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})).With("version", version, "commit", commit)
 
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return errors.Wrap(err, "ReadAll failed")
+// requestID returns the API Gateway request ID for correlating log lines.
+func requestID(ctx context.Context) string {
+	apiGwContext, ok := core.GetAPIGatewayContextFromContext(ctx)
+	if !ok {
+		return ""
 	}
+	return apiGwContext.RequestID
+}
 
-	// we need to reset the body to avoid unexpected side effects
-	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+var (
+	// router serves every route, behind Lambda or a local HTTP server.
+	router            *http.ServeMux
+	handlerFuncLambda *handlerfunc.HandlerFuncAdapter
+	// canary answers scheduled events.
+	canary func(context.Context) error
+)
 
-	_, err = secretVerifier.Write(body)
+// setup loads the Config and builds the app and its routes. It runs from main
+// rather than init so tests can build their own app.
+func setup() {
+	config, err := loadConfig()
 	if err != nil {
-		return errors.Wrap(err, "Ensure failed")
+		logger.Error("could not start", "error", err)
+		os.Exit(1)
+	}
+	logLevel.Set(config.LogLevel)
+	upstreamClient = traceClient(newUpstreamClient(config.UpstreamTimeout))
+	configureUpstream(config)
+
+	sess := session.Must(session.NewSession())
+	secrets := newSecretsProvider(sess)
+	a := &app{
+		config:      config,
+		source:      newTokenSource(secrets),
+		secrets:     secrets,
+		audit:       newAuditWriter(sess),
+		limiter:     newRateLimiter(sess, config),
+		notifier:    newNotifier(),
+		idempotency: newIdempotencyStore(sess),
+		tokenCache:  newTokenCache(sess, secrets),
 	}
 
-	err = secretVerifier.Ensure()
-	if err != nil {
-		return errors.Wrap(err, "Ensure failed")
+	router = http.NewServeMux()
+	router.HandleFunc("/health", a.healthHandler)
+	router.HandleFunc("/interactions", a.interactionHandler)
+	router.HandleFunc("/", a.handler)
+	if httpMode() {
+		registerPrometheus()
 	}
+	handlerFuncLambda = handlerfunc.New(router.ServeHTTP)
+	canary = a.canary
+}
 
-	return nil
+// lambdaEvent holds the fields that tell scheduled events and warm-up pings
+// apart from API Gateway proxy requests.
+type lambdaEvent struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
 }
 
-func getToken(bearerToken string, address string) (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", address, nil)
-	if err != nil {
-		return "", err
-	}
+// isScheduled reports whether e is an EventBridge scheduled event.
+func (e lambdaEvent) isScheduled() bool {
+	return e.Source == "aws.events" && e.DetailType == "Scheduled Event"
+}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", bearerToken))
+// isWarmup reports whether e is a serverless-plugin-warmup ping.
+func (e lambdaEvent) isWarmup() bool {
+	return e.Source == "serverless-plugin-warmup"
+}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return "", err
+// Handler routes an invocation by event type: warm-up pings return at once,
+// EventBridge scheduled events run the canary, and everything else is
+// treated as an API Gateway proxy request.
+func Handler(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	var e lambdaEvent
+	if err := json.Unmarshal(event, &e); err == nil {
+		switch {
+		case e.isWarmup():
+			// Keep the instance warm without verifying, minting or logging.
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+		case e.isScheduled():
+			return nil, canary(ctx)
+		}
 	}
 
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return "", err
+	var req events.APIGatewayProxyRequest
+	if err := json.Unmarshal(event, &req); err != nil {
+		return nil, err
 	}
+	return handlerFuncLambda.ProxyWithContext(ctx, req)
+}
 
-	return strings.TrimSuffix(string(body), "\n"), nil
+// httpMode reports whether RUN_MODE selects the local HTTP server instead of
+// Lambda.
+func httpMode() bool {
+	return os.Getenv("RUN_MODE") == "http"
 }
 
-func handler(w http.ResponseWriter, req *http.Request) {
-	if verifyRequest(req) != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+// defaultListenAddr is where RUN_MODE=http listens unless PORT is set.
+const defaultListenAddr = ":8080"
 
-	s, err := slack.SlashCommandParse(req)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+// main runs under Lambda by default. RUN_MODE=http serves the same routes
+// from a plain HTTP server instead, for running and curling locally.
+func main() {
+	setup()
+	if !httpMode() {
+		lambda.Start(Handler)
 		return
 	}
 
-	var (
-		bearerToken string
-		address     string
-		environment string
-	)
-
-	text := s.Text
-	demo := regexp.MustCompile("(?i)demo")
-	staging := regexp.MustCompile("(?i)staging")
-
-	if demo.MatchString(text) {
-		bearerToken = os.Getenv("DEMO")
-		address = demoAddress
-		environment = "Demo"
-	} else if staging.MatchString(text) {
-		bearerToken = os.Getenv("STAGING")
-		address = stagingAddress
-		environment = "Staging"
-	} else {
-		w.Write([]byte("Please enter either *demo* or *staging*"))
-		return
+	addr := defaultListenAddr
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
 	}
 
-	token, err := getToken(bearerToken, address)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	logger.Info("listening", "addr", addr)
+	if err := http.ListenAndServe(addr, router); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
 	}
-
-	w.Write([]byte(fmt.Sprintf("%v token: %v", environment, token)))
-}
-
-var handlerFuncLambda *handlerfunc.HandlerFuncAdapter
-
-func init() {
-	handlerFuncLambda = handlerfunc.New(handler)
-}
-
-// Handler foo
-func Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	return handlerFuncLambda.ProxyWithContext(ctx, req)
-}
-
-func main() {
-	lambda.Start(Handler)
 }
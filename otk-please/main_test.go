@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/awslabs/aws-lambda-go-api-proxy/handlerfunc"
+)
+
+// stubRoutes replaces the router and canary Handler dispatches to, counting
+// what reaches each.
+func stubRoutes(t *testing.T) (requests, canaries *int) {
+	t.Helper()
+	requests, canaries = new(int), new(int)
+	previousLambda, previousCanary := handlerFuncLambda, canary
+	handlerFuncLambda = handlerfunc.New(func(w http.ResponseWriter, req *http.Request) {
+		*requests++
+		w.WriteHeader(http.StatusTeapot)
+	})
+	canary = func(ctx context.Context) error {
+		*canaries++
+		return nil
+	}
+	t.Cleanup(func() { handlerFuncLambda, canary = previousLambda, previousCanary })
+	return requests, canaries
+}
+
+func TestHandlerWarmup(t *testing.T) {
+	requests, canaries := stubRoutes(t)
+
+	res, err := Handler(context.Background(), []byte(`{"source": "serverless-plugin-warmup"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, ok := res.(events.APIGatewayProxyResponse)
+	if !ok || proxy.StatusCode != http.StatusOK {
+		t.Errorf("Handler() = %#v, want a 200 proxy response", res)
+	}
+	if *requests != 0 || *canaries != 0 {
+		t.Errorf("warm-up reached %v routes and %v canaries, want none", *requests, *canaries)
+	}
+}
+
+func TestHandlerDispatch(t *testing.T) {
+	requests, canaries := stubRoutes(t)
+
+	if _, err := Handler(context.Background(), []byte(`{"source": "aws.events", "detail-type": "Scheduled Event"}`)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Handler(context.Background(), []byte(`{"httpMethod": "POST", "path": "/", "body": ""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy, ok := res.(events.APIGatewayProxyResponse); !ok || proxy.StatusCode != http.StatusTeapot {
+		t.Errorf("Handler() = %#v, want the router's response", res)
+	}
+	if *requests != 1 || *canaries != 1 {
+		t.Errorf("got %v requests and %v canaries, want one of each", *requests, *canaries)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import "fmt"
+
+// locale selects the language of user-facing replies.
+type locale string
+
+const (
+	english locale = "en"
+	french  locale = "fr"
+)
+
+// message identifies a user-facing string in the catalog.
+type message string
+
+const (
+	msgNotAuthorized      message = "not_authorized"
+	msgInvalidCount       message = "invalid_count"
+	msgInputTooLong       message = "input_too_long"
+	msgUnknownEnvironment message = "unknown_environment"
+	msgNoEnvironments     message = "no_environments"
+	msgAmbiguous          message = "ambiguous_environment"
+	msgNotEnabled         message = "not_enabled"
+	msgInvalidSlug        message = "invalid_slug"
+	msgChannelNotAllowed  message = "channel_not_allowed"
+	msgTooManyTokens      message = "too_many_tokens"
+	msgNoCredentials      message = "no_credentials"
+	msgNotConfigured      message = "not_configured"
+	msgTimeout            message = "timeout"
+	msgTookTooLong        message = "took_too_long"
+	msgUpstreamStatus     message = "upstream_status"
+	msgUpstreamFailed     message = "upstream_failed"
+	msgMalformedToken     message = "malformed_token"
+	msgUnavailable        message = "unavailable"
+	msgBusy               message = "busy"
+	msgWorking            message = "working"
+	msgToken              message = "token"
+	msgTokens             message = "tokens"
+	msgTokenTitle         message = "token_title"
+	msgTokensTitle        message = "tokens_title"
+	msgRemaining          message = "remaining"
+	msgRequestedBy        message = "requested_by"
+	msgExpires            message = "expires"
+	msgHelpUsage          message = "help_usage"
+	msgHelpEnvironment    message = "help_environment"
+	msgHelpDefault        message = "help_default"
+	msgHelpCount          message = "help_count"
+	msgHelpPublic         message = "help_public"
+	msgHelpLanguage       message = "help_language"
+	msgHelpDryRun         message = "help_dry_run"
+	msgHelpQR             message = "help_qr"
+	msgHelpFormat         message = "help_format"
+	msgHelpVersion        message = "help_version"
+	msgHelpList           message = "help_list"
+	msgHelpRevoke         message = "help_revoke"
+	msgRevokeUsage        message = "revoke_usage"
+	msgRevokeUnavailable  message = "revoke_unavailable"
+	msgRevoked            message = "revoked"
+	msgRevokeFailed       message = "revoke_failed"
+	msgHelpAudit          message = "help_audit"
+	msgAuditDisabled      message = "audit_disabled"
+	msgAuditFailed        message = "audit_failed"
+	msgAuditSummary       message = "audit_summary"
+	msgAuditUploadFailed  message = "audit_upload_failed"
+	msgHelpURL            message = "help_url"
+	msgHelpGroups         message = "help_groups"
+	msgFeatureDisabled    message = "feature_disabled"
+	msgCachedTokens       message = "cached_tokens"
+	msgHelpWhoami         message = "help_whoami"
+	msgPartialMint        message = "partial_mint"
+	msgWhoami             message = "whoami"
+	msgWhoamiNone         message = "whoami_none"
+	msgYes                message = "yes"
+	msgNo                 message = "no"
+	msgTokenFile          message = "token_file"
+	msgTokenFileFailed    message = "token_file_failed"
+	msgUpstreamDown       message = "upstream_down"
+	msgHelpErrors         message = "help_errors"
+	msgRecentErrors       message = "recent_errors"
+	msgNoRecentErrors     message = "no_recent_errors"
+	msgCertificateError   message = "certificate_error"
+	msgMaintenance        message = "maintenance"
+	msgListDisabled       message = "list_disabled"
+	msgHelpConfigCheck    message = "help_config_check"
+	msgConfigCheckTitle   message = "config_check_title"
+	msgConfigOK           message = "config_ok"
+	msgConfigError        message = "config_error"
+	msgConfigSkipped      message = "config_skipped"
+	msgDidYouMean         message = "did_you_mean"
+	msgUnknownCommand     message = "unknown_command"
+	msgGroupRejected      message = "group_rejected"
+	msgInvalidURL         message = "invalid_url"
+	msgListTitle          message = "list_title"
+	msgListAliases        message = "list_aliases"
+	msgListSensitive      message = "list_sensitive"
+	msgVersion            message = "version"
+	msgDryRunOK           message = "dry_run_ok"
+	msgDryRunNoToken      message = "dry_run_no_token"
+	msgRateLimited        message = "rate_limited"
+	msgTooConcurrent      message = "too_concurrent"
+	msgAlreadyProcessed   message = "already_processed"
+	msgSensitiveMint      message = "sensitive_mint"
+	msgMintAnother        message = "mint_another"
+)
+
+// catalog holds every user-facing string by locale. Keep the locales in sync
+// when adding a message.
+var catalog = map[locale]map[message]string{
+	english: {
+		msgNotAuthorized:      "You are not authorized to use this command",
+		msgInvalidCount:       "Please enter a token count of at least 1",
+		msgInputTooLong:       "Input too long.",
+		msgUnknownEnvironment: "Please enter one of %v",
+		msgNoEnvironments:     "No environments are available in this channel",
+		msgAmbiguous:          "*%v* could mean %v, please pick one",
+		msgNotEnabled:         "%v is not enabled",
+		msgInvalidSlug:        "Please name the preview environment like `preview pr-123`",
+		msgChannelNotAllowed:  "%v tokens can only be minted in %v",
+		msgTooManyTokens:      "You can mint at most %v tokens at a time",
+		msgNoCredentials:      "Could not load credentials for %v",
+		msgNotConfigured:      "This environment is not configured; contact an administrator",
+		msgTimeout:            "Timed out waiting for %v to mint a token, please try again",
+		msgTookTooLong:        "The request took too long; please try again",
+		msgUpstreamStatus:     "Could not mint a token for %v (upstream returned %v)",
+		msgUpstreamFailed:     "Could not mint a token for %v",
+		msgMalformedToken:     "%v did not return a valid token, please report this",
+		msgUnavailable:        "Token service is currently unavailable, please try again shortly.",
+		msgBusy:               "The token service is busy, try again later.",
+		msgWorking:            "Working on it...",
+		msgToken:              "%v token for %v: %v",
+		msgTokens:             "%v tokens for %v:",
+		msgTokenTitle:         "%v token",
+		msgTokensTitle:        "%v tokens",
+		msgRemaining:          "(%v remaining)",
+		msgRequestedBy:        "For %v",
+		msgExpires:            "(expires in %v, %v)",
+		msgHelpUsage:          "*Usage:* `%v <environment> [count] [public] [fr]`",
+		msgHelpEnvironment:    "• `environment`: one of %v",
+		msgHelpDefault:        "  (leave it out to use *%v*)",
+		msgHelpCount:          "• `count`: how many tokens to mint, up to %v (default 1)",
+		msgHelpPublic:         "• `public`: post the reply in the channel; by default only you can see it",
+		msgHelpLanguage:       "• `fr`: reply in French",
+		msgHelpDryRun:         "• `--dry-run`: check the environment is configured without minting",
+		msgHelpQR:             "• `qr`: also send each token as a QR code",
+		msgHelpFormat:         "• `format=json`: reply with JSON for scripts, still only visible to you unless `public`",
+		msgHelpVersion:        "• `version`: show which build is deployed",
+		msgHelpList:           "• `envs` or `list`: list the available environments",
+		msgHelpRevoke:         "• `revoke [environment] <token>`: invalidate a token",
+		msgRevokeUsage:        "Please name the token to revoke, like `revoke demo ABC123`",
+		msgRevokeUnavailable:  "Revocation is not available for this environment.",
+		msgRevoked:            "Revoked the %v token",
+		msgRevokeFailed:       "Could not revoke the %v token",
+		msgHelpAudit:          "• `audit <environment> [days]`: admins only, send yourself the audit records",
+		msgAuditDisabled:      "Auditing is not enabled",
+		msgAuditFailed:        "Could not read the %v audit records",
+		msgAuditSummary:       "%v %v token(s) were minted by %v command(s) in the last %v day(s)",
+		msgAuditUploadFailed:  "The records could not be sent as a file.",
+		msgHelpURL:            "• `url=<https://...>`: admins only, mint against an upstream not in the registry",
+		msgHelpGroups:         "• `demo 2 staging 3`: mint from several environments at once",
+		msgFeatureDisabled:    "This feature is not enabled",
+		msgCachedTokens:       "You minted this %v ago, so here it is again rather than a new one-time key.",
+		msgHelpWhoami:         "• `whoami`: show your Slack IDs and what you can access",
+		msgPartialMint:        "%v of %v tokens could not be minted: %v",
+		msgWhoami:             "User: `%v`\nTeam: `%v`\nEnterprise: `%v`\nChannel: `%v`\nAllowed: %v\nAdmin: %v\nEnvironments here: %v",
+		msgWhoamiNone:         "none",
+		msgYes:                "yes",
+		msgNo:                 "no",
+		msgTokenFile:          "Your %v token(s) were sent to you as a file: %v",
+		msgTokenFileFailed:    ":warning: The tokens could not be sent as a file, so they are shown here instead.",
+		msgUpstreamDown:       "%v appears to be down, not minting",
+		msgHelpErrors:         "• `errors [count]`: admins only, show the latest errors seen by this instance",
+		msgRecentErrors:       "Last %v error(s) seen by this instance:",
+		msgNoRecentErrors:     "This instance has seen no errors since it started.",
+		msgCertificateError:   "Could not establish a secure connection to %v (certificate error)",
+		msgMaintenance:        "%v is temporarily disabled for maintenance",
+		msgListDisabled:       "(disabled)",
+		msgHelpConfigCheck:    "• `config-check`: admins only, validate every environment's configuration without minting",
+		msgConfigCheckTitle:   "Configuration check:",
+		msgConfigOK:           ":white_check_mark: *%v*: OK",
+		msgConfigError:        ":x: *%v*: ERROR: %v",
+		msgConfigSkipped:      ":white_circle: *%v*: not enabled, skipped",
+		msgDidYouMean:         "Did you mean '%v'?",
+		msgUnknownCommand:     "`%v` is not a command this app answers",
+		msgGroupRejected:      "Nothing was minted because of *%v*: %v",
+		msgInvalidURL:         "That URL is not allowed. It must be https and on an allowed upstream host.",
+		msgListTitle:          "Available environments:",
+		msgListAliases:        "(also %v)",
+		msgListSensitive:      ":lock: sensitive",
+		msgVersion:            "Version %v (commit %v, built %v)",
+		msgDryRunOK:           "Dry run OK for %v",
+		msgDryRunNoToken:      "Dry run failed for %v: no bearer token is configured",
+		msgRateLimited:        "Rate limit reached, try again in %v seconds",
+		msgTooConcurrent:      "Too many concurrent requests, try again.",
+		msgAlreadyProcessed:   "This command was already processed.",
+		msgSensitiveMint:      ":rotating_light: <@%v> minted %v %v token(s) in <#%v> at %v",
+		msgMintAnother:        "Mint another",
+	},
+	french: {
+		msgNotAuthorized:      "Vous n’êtes pas autorisé à utiliser cette commande",
+		msgInvalidCount:       "Veuillez entrer un nombre de jetons d’au moins 1",
+		msgInputTooLong:       "Texte trop long.",
+		msgUnknownEnvironment: "Veuillez entrer l’un de %v",
+		msgNoEnvironments:     "Aucun environnement n’est disponible dans ce canal",
+		msgAmbiguous:          "*%v* peut désigner %v, veuillez en choisir un",
+		msgNotEnabled:         "%v n’est pas activé",
+		msgInvalidSlug:        "Veuillez nommer l’environnement d’aperçu comme `preview pr-123`",
+		msgChannelNotAllowed:  "Les jetons %v ne peuvent être générés que dans %v",
+		msgTooManyTokens:      "Vous pouvez générer au plus %v jetons à la fois",
+		msgNoCredentials:      "Impossible de charger les identifiants pour %v",
+		msgNotConfigured:      "Cet environnement n’est pas configuré; communiquez avec un administrateur",
+		msgTimeout:            "Délai dépassé en attendant un jeton de %v, veuillez réessayer",
+		msgTookTooLong:        "La demande a pris trop de temps; veuillez réessayer",
+		msgUpstreamStatus:     "Impossible de générer un jeton pour %v (le serveur a répondu %v)",
+		msgUpstreamFailed:     "Impossible de générer un jeton pour %v",
+		msgMalformedToken:     "%v n’a pas renvoyé de jeton valide, veuillez le signaler",
+		msgUnavailable:        "Le service de jetons est actuellement indisponible, veuillez réessayer sous peu.",
+		msgBusy:               "Le service de jetons est occupé, réessayez plus tard.",
+		msgWorking:            "Traitement en cours...",
+		msgToken:              "Jeton %v pour %v : %v",
+		msgTokens:             "Jetons %v pour %v :",
+		msgTokenTitle:         "Jeton %v",
+		msgTokensTitle:        "Jetons %v",
+		msgRemaining:          "(%v restants)",
+		msgRequestedBy:        "Pour %v",
+		msgExpires:            "(expire dans %v, %v)",
+		msgHelpUsage:          "*Utilisation :* `%v <environnement> [nombre] [public] [fr]`",
+		msgHelpEnvironment:    "• `environnement` : %v",
+		msgHelpDefault:        "  (omettez-le pour utiliser *%v*)",
+		msgHelpCount:          "• `nombre` : nombre de jetons à générer, jusqu’à %v (1 par défaut)",
+		msgHelpPublic:         "• `public` : publier la réponse dans le canal; par défaut, vous seul la voyez",
+		msgHelpLanguage:       "• `fr` : répondre en français",
+		msgHelpDryRun:         "• `--dry-run` : vérifier la configuration de l’environnement sans générer de jeton",
+		msgHelpQR:             "• `qr` : envoyer aussi chaque jeton sous forme de code QR",
+		msgHelpFormat:         "• `format=json` : répondre en JSON pour les scripts, visible seulement par vous sauf avec `public`",
+		msgHelpVersion:        "• `version` : afficher la version déployée",
+		msgHelpList:           "• `envs` ou `list` : lister les environnements disponibles",
+		msgHelpRevoke:         "• `revoke [environnement] <jeton>` : invalider un jeton",
+		msgRevokeUsage:        "Veuillez indiquer le jeton à révoquer, par exemple `revoke demo ABC123`",
+		msgRevokeUnavailable:  "La révocation n’est pas offerte pour cet environnement.",
+		msgRevoked:            "Le jeton %v a été révoqué",
+		msgRevokeFailed:       "Impossible de révoquer le jeton %v",
+		msgHelpAudit:          "• `audit <environnement> [jours]` : administrateurs seulement, recevoir les registres d’audit",
+		msgAuditDisabled:      "L’audit n’est pas activé",
+		msgAuditFailed:        "Impossible de lire les registres d’audit %v",
+		msgAuditSummary:       "%v jeton(s) %v ont été générés par %v commande(s) au cours des %v dernier(s) jour(s)",
+		msgAuditUploadFailed:  "Les registres n’ont pas pu être envoyés sous forme de fichier.",
+		msgHelpURL:            "• `url=<https://...>` : administrateurs seulement, générer auprès d’un serveur absent du registre",
+		msgHelpGroups:         "• `demo 2 staging 3` : générer auprès de plusieurs environnements à la fois",
+		msgFeatureDisabled:    "Cette fonctionnalité n’est pas activée",
+		msgCachedTokens:       "Vous l’avez généré il y a %v, le voici de nouveau plutôt qu’une nouvelle clé à usage unique.",
+		msgHelpWhoami:         "• `whoami` : afficher vos identifiants Slack et vos accès",
+		msgPartialMint:        "%v jeton(s) sur %v n’ont pas pu être générés : %v",
+		msgWhoami:             "Utilisateur : `%v`\nÉquipe : `%v`\nEntreprise : `%v`\nCanal : `%v`\nAutorisé : %v\nAdministrateur : %v\nEnvironnements ici : %v",
+		msgWhoamiNone:         "aucun",
+		msgYes:                "oui",
+		msgNo:                 "non",
+		msgTokenFile:          "Vos jetons %v vous ont été envoyés sous forme de fichier : %v",
+		msgTokenFileFailed:    ":warning: Les jetons n’ont pas pu être envoyés sous forme de fichier; les voici.",
+		msgUpstreamDown:       "%v semble être hors service, aucun jeton généré",
+		msgHelpErrors:         "• `errors [nombre]` : administrateurs seulement, afficher les dernières erreurs vues par cette instance",
+		msgRecentErrors:       "Dernière(s) %v erreur(s) vue(s) par cette instance :",
+		msgNoRecentErrors:     "Cette instance n’a vu aucune erreur depuis son démarrage.",
+		msgCertificateError:   "Impossible d’établir une connexion sécurisée avec %v (erreur de certificat)",
+		msgMaintenance:        "%v est temporairement désactivé pour maintenance",
+		msgListDisabled:       "(désactivé)",
+		msgHelpConfigCheck:    "• `config-check` : administrateurs seulement, valider la configuration de chaque environnement sans générer de jeton",
+		msgConfigCheckTitle:   "Vérification de la configuration :",
+		msgConfigOK:           ":white_check_mark: *%v* : OK",
+		msgConfigError:        ":x: *%v* : ERREUR : %v",
+		msgConfigSkipped:      ":white_circle: *%v* : non activé, ignoré",
+		msgDidYouMean:         "Vouliez-vous dire « %v »?",
+		msgUnknownCommand:     "`%v` n’est pas une commande à laquelle cette application répond",
+		msgGroupRejected:      "Aucun jeton n’a été généré à cause de *%v* : %v",
+		msgInvalidURL:         "Cette URL n’est pas autorisée. Elle doit être en https et sur un hôte autorisé.",
+		msgListTitle:          "Environnements disponibles :",
+		msgListAliases:        "(aussi %v)",
+		msgListSensitive:      ":lock: sensible",
+		msgVersion:            "Version %v (commit %v, compilée le %v)",
+		msgDryRunOK:           "Essai à blanc réussi pour %v",
+		msgDryRunNoToken:      "Échec de l’essai à blanc pour %v : aucun jeton d’accès n’est configuré",
+		msgRateLimited:        "Limite atteinte, réessayez dans %v secondes",
+		msgTooConcurrent:      "Trop de demandes simultanées, réessayez.",
+		msgAlreadyProcessed:   "Cette commande a déjà été traitée.",
+		msgSensitiveMint:      ":rotating_light: <@%v> a généré %v jeton(s) %v dans <#%v> le %v",
+		msgMintAnother:        "En générer un autre",
+	},
+}
+
+// text formats key in l, falling back to English for unknown locales.
+func (l locale) text(key message, args ...interface{}) string {
+	messages, ok := catalog[l]
+	if !ok {
+		messages = catalog[english]
+	}
+	return fmt.Sprintf(messages[key], args...)
+}
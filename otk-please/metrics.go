@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsNamespace is the CloudWatch namespace our metrics are published under.
+const metricsNamespace = "OTKPlease"
+
+// metricsOutput is where EMF lines are written. Lambda ships stdout to
+// CloudWatch Logs, which extracts the metrics.
+var metricsOutput io.Writer = os.Stdout
+
+// flushTimeout bounds flush so it can never push a handler past the Lambda
+// deadline.
+const flushTimeout = 100 * time.Millisecond
+
+// metricsBuffer holds EMF lines until flush writes them to metricsOutput.
+var metricsBuffer struct {
+	mu    sync.Mutex
+	lines [][]byte
+}
+
+// flush writes out buffered metrics. Handlers defer it so lines are written
+// before Lambda freezes the process, including when the reply went out
+// through response_url. It stops waiting after flushTimeout or when ctx ends,
+// leaving the write to finish in the background if the process thaws.
+func flush(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+
+	metricsBuffer.mu.Lock()
+	lines := metricsBuffer.lines
+	metricsBuffer.lines = nil
+	metricsBuffer.mu.Unlock()
+	if len(lines) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, line := range lines {
+			metricsOutput.Write(line)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("gave up flushing metrics", "lines", len(lines))
+	}
+}
+
+func metricsEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("METRICS_ENABLED"))
+	return err == nil && enabled
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// emitTokenMetrics records one mint attempt as an embedded metric format
+// (EMF) line, dimensioned by environment and outcome, and in the Prometheus
+// collectors.
+//
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func emitTokenMetrics(environment string, success bool, latency time.Duration) {
+	outcome := "success"
+	if !success {
+		outcome = "failure"
+	}
+
+	promTokenRequests.WithLabelValues(environment, outcome).Inc()
+	promTokenLatency.WithLabelValues(environment).Observe(latency.Seconds())
+
+	if !metricsEnabled() {
+		return
+	}
+
+	writeEMF([]string{"Environment", "Outcome"}, []emfMetric{
+		{Name: "TokenRequests", Unit: "Count"},
+		{Name: "Latency", Unit: "Milliseconds"},
+	}, map[string]interface{}{
+		"Environment":   environment,
+		"Outcome":       outcome,
+		"TokenRequests": 1,
+		"Latency":       latency.Milliseconds(),
+	})
+}
+
+// emitCanaryMetrics records the outcome of a scheduled canary mint against
+// environment: 1 for success, 0 for failure, so alarms can watch the minimum.
+func emitCanaryMetrics(environment string, success bool) {
+	if !metricsEnabled() {
+		return
+	}
+
+	value := 0
+	if success {
+		value = 1
+	}
+	writeEMF([]string{"Environment"}, []emfMetric{
+		{Name: "CanarySuccess", Unit: "Count"},
+	}, map[string]interface{}{
+		"Environment":   environment,
+		"CanarySuccess": value,
+	})
+}
+
+// emitMalformedToken records an upstream answering with something that isn't
+// a token.
+func emitMalformedToken(environment string) {
+	if !metricsEnabled() {
+		return
+	}
+
+	writeEMF([]string{"Environment"}, []emfMetric{
+		{Name: "MalformedTokens", Unit: "Count"},
+	}, map[string]interface{}{
+		"Environment":     environment,
+		"MalformedTokens": 1,
+	})
+}
+
+// emitDeliveryFailure records a reply that could not be posted to
+// response_url. The user sees nothing in that case, so it is worth alarming
+// on.
+func emitDeliveryFailure(environment string) {
+	if !metricsEnabled() {
+		return
+	}
+
+	writeEMF([]string{"Environment"}, []emfMetric{
+		{Name: "DelayedResponseFailures", Unit: "Count"},
+	}, map[string]interface{}{
+		"Environment":             environment,
+		"DelayedResponseFailures": 1,
+	})
+}
+
+// writeEMF buffers one EMF line publishing metrics, dimensioned by dimensions.
+// fields holds the dimension and metric values.
+func writeEMF(dimensions []string, metrics []emfMetric, fields map[string]interface{}) {
+	fields["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  metricsNamespace,
+			Dimensions: [][]string{dimensions},
+			Metrics:    metrics,
+		}},
+	}
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		logger.Error("could not encode metrics", "error", err)
+		return
+	}
+
+	metricsBuffer.mu.Lock()
+	metricsBuffer.lines = append(metricsBuffer.lines, append(line, '\n'))
+	metricsBuffer.mu.Unlock()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureMetrics points metricsOutput at a buffer for the test.
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	t.Setenv("METRICS_ENABLED", "true")
+	var out bytes.Buffer
+	previous := metricsOutput
+	metricsOutput = &out
+	t.Cleanup(func() { metricsOutput = previous })
+	return &out
+}
+
+func TestFlushWritesBufferedMetrics(t *testing.T) {
+	out := captureMetrics(t)
+
+	emitTokenMetrics("Demo", true, 42*time.Millisecond)
+	emitTokenMetrics("Staging", false, 7*time.Millisecond)
+	if out.Len() != 0 {
+		t.Fatalf("metrics were written before flush: %q", out)
+	}
+
+	flush(context.Background())
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("flush wrote %v lines, want 2: %q", len(lines), out)
+	}
+	var first struct {
+		Environment   string      `json:"Environment"`
+		Outcome       string      `json:"Outcome"`
+		TokenRequests int         `json:"TokenRequests"`
+		Latency       int64       `json:"Latency"`
+		AWS           emfMetadata `json:"_aws"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Environment != "Demo" || first.Outcome != "success" || first.TokenRequests != 1 || first.Latency != 42 {
+		t.Errorf("first line = %+v", first)
+	}
+	if len(first.AWS.CloudWatchMetrics) != 1 || first.AWS.CloudWatchMetrics[0].Namespace != metricsNamespace {
+		t.Errorf("_aws = %+v, want the %v namespace", first.AWS, metricsNamespace)
+	}
+
+	// The buffer is emptied, so a second flush writes nothing new.
+	out.Reset()
+	flush(context.Background())
+	if out.Len() != 0 {
+		t.Errorf("second flush wrote %q", out)
+	}
+}
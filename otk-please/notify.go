@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// notifyTimeout bounds the audit webhook call so it can't hold up the reply.
+const notifyTimeout = 2 * time.Second
+
+// notifier tells the security audit channel, through the incoming webhook in
+// AUDIT_WEBHOOK_URL, whenever tokens are minted for a sensitive environment.
+// A nil *notifier does nothing.
+type notifier struct {
+	url    string
+	client *http.Client
+}
+
+func newNotifier() *notifier {
+	url := os.Getenv("AUDIT_WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+	return &notifier{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+// sensitiveMint reports who minted count tokens for env. It never includes
+// the token values and skips environments that aren't sensitive.
+func (n *notifier) sensitiveMint(ctx context.Context, s slack.SlashCommand, env environment, count int) error {
+	if n == nil || !env.sensitive {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	text := english.text(msgSensitiveMint, s.UserID, count, env.name, s.ChannelID, time.Now().UTC().Format(time.RFC3339))
+	return slack.PostWebhookCustomHTTPContext(ctx, n.url, n.client, &slack.WebhookMessage{Text: text})
+}
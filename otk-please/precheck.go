@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// precheckTimeout bounds the health probe so a hung upstream is reported
+// quickly rather than eating into the time left to mint.
+const precheckTimeout = time.Second
+
+// defaultHealthPath is where submission servers answer health checks.
+const defaultHealthPath = "/services/ping"
+
+// healthURL is the full upstream URL probed before minting.
+func (e environment) healthURL() string {
+	if e.healthPath == "" {
+		return e.baseURL + defaultHealthPath
+	}
+	return e.baseURL + e.healthPath
+}
+
+// probeHealth checks that env's submission server answers its health path
+// with a 2xx before any one-time key is used up against it. The probe carries
+// the environment's extra headers but never the bearer token.
+func probeHealth(ctx context.Context, env environment) error {
+	if err := checkUpstreamHost(env.healthURL()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, precheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, env.healthURL(), nil)
+	if err != nil {
+		return err
+	}
+	env.setHeaders(req)
+
+	res, err := upstreamClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "health probe failed")
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.Errorf("health probe returned %v", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrecheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		health int
+		minted bool
+	}{
+		{name: "healthy", health: http.StatusOK, minted: true},
+		{name: "failing", health: http.StatusServiceUnavailable},
+		{name: "missing health path", health: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probes int
+			var authorization string
+			upstream := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == defaultHealthPath {
+					probes++
+					authorization = req.Header.Get("Authorization")
+					w.WriteHeader(tt.health)
+				}
+			})
+			minted := false
+			a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+				minted = true
+				return Token{Value: "TOKEN1234"}, nil
+			}), map[string]string{"PRECHECK_ENABLED": "true", "DEMO_URL": upstream.baseURL})
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+
+			if probes != 1 {
+				t.Fatalf("probed %v times, want 1", probes)
+			}
+			if authorization != "" {
+				t.Error("the health probe sent the bearer token")
+			}
+			if minted != tt.minted {
+				t.Errorf("minted = %v, want %v", minted, tt.minted)
+			}
+			msg := decodeResponse(t, rec)
+			if down := msg.Text == english.text(msgUpstreamDown, "Demo"); down == tt.minted {
+				t.Errorf("reply = %q (%v)", msg.Text, msg.ErrorCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The Prometheus collectors mirror the EMF metrics. They are only registered
+// and served in HTTP run mode, since nothing can scrape a Lambda; observing
+// them otherwise is harmless.
+var (
+	promTokenRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "otk_please",
+		Name:      "token_requests_total",
+		Help:      "Mint attempts by environment and outcome.",
+	}, []string{"environment", "outcome"})
+
+	promTokenLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "otk_please",
+		Name:      "token_request_duration_seconds",
+		Help:      "Time taken to mint the tokens for one command.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"environment"})
+)
+
+// registerPrometheus exposes the collectors at /metrics on router.
+func registerPrometheus() {
+	prometheus.MustRegister(promTokenRequests, promTokenLatency)
+	router.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/skip2/go-qrcode"
+	"github.com/slack-go/slack"
+)
+
+// qrSize is the width and height of generated QR codes in pixels.
+const qrSize = 256
+
+// uploadQRCodes uploads a QR code PNG for each token to channel with the bot
+// in SLACK_BOT_TOKEN. Empty tokens are skipped.
+func uploadQRCodes(ctx context.Context, l locale, environment string, tokens []Token, channel string) error {
+	botToken := os.Getenv("SLACK_BOT_TOKEN")
+	if botToken == "" {
+		return errors.New("SLACK_BOT_TOKEN is not set")
+	}
+	api := newSlackClient(botToken)
+
+	for i, token := range tokens {
+		if token.Value == "" {
+			continue
+		}
+
+		png, err := qrcode.Encode(token.Value, qrcode.Medium, qrSize)
+		if err != nil {
+			return errors.Wrap(err, "Encode failed")
+		}
+
+		_, err = api.UploadFileContext(ctx, slack.FileUploadParameters{
+			Reader:   bytes.NewReader(png),
+			Filetype: "png",
+			Filename: fmt.Sprintf("%v-token-%v.png", strings.ToLower(environment), i+1),
+			Title:    l.text(msgTokenTitle, environment),
+			Channels: []string{channel},
+		})
+		if err != nil {
+			return errors.Wrap(err, "UploadFile failed")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRateLimitMax is used when RATE_LIMIT_MAX is unset.
+	defaultRateLimitMax = 20
+	// defaultRateLimitWindow is used when RATE_LIMIT_WINDOW_SECONDS is unset.
+	defaultRateLimitWindow = time.Hour
+)
+
+// rateLimiter caps how many tokens a user can mint per window.
+type rateLimiter interface {
+	// allow records n mints for userID. When the limit has been reached it
+	// records nothing and returns how long until the user can mint again;
+	// when n alone is over the limit it returns an *overLimitError.
+	allow(ctx context.Context, userID string, n int) (time.Duration, error)
+}
+
+// dynamoRateLimiter keeps a per-user counter in DynamoDB. A user's window
+// opens with their first mint and lasts window; expired items are cleaned up
+// by the table's TTL on expires_at.
+type dynamoRateLimiter struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	max    int
+	window time.Duration
+}
+
+// newRateLimiter returns a limiter backed by config's RateLimitTable, or nil
+// to disable rate limiting when it is empty.
+func newRateLimiter(sess *session.Session, config Config) rateLimiter {
+	if config.RateLimitTable == "" {
+		return nil
+	}
+	return &dynamoRateLimiter{
+		client: dynamodb.New(sess),
+		table:  config.RateLimitTable,
+		max:    config.RateLimitMax,
+		window: config.RateLimitWindow,
+	}
+}
+
+// overLimitError is returned by allow for more mints than the whole limit,
+// which no amount of waiting would let through.
+type overLimitError struct {
+	max int
+}
+
+func (e *overLimitError) Error() string {
+	return fmt.Sprintf("more than the rate limit of %v", e.max)
+}
+
+func number(n int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+func conditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func (l *dynamoRateLimiter) allow(ctx context.Context, userID string, n int) (time.Duration, error) {
+	if n > l.max {
+		return 0, &overLimitError{max: l.max}
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-l.window).Unix()
+	key := map[string]*dynamodb.AttributeValue{"user_id": {S: aws.String(userID)}}
+
+	// Count against the user's open window if it has room left.
+	_, err := l.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET mints = mints + :n"),
+		ConditionExpression: aws.String("window_start > :cutoff AND mints <= :room"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n":      number(int64(n)),
+			":cutoff": number(cutoff),
+			":room":   number(int64(l.max - n)),
+		},
+	})
+	if err == nil {
+		return 0, nil
+	}
+	if !conditionFailed(err) {
+		return 0, errors.Wrap(err, "UpdateItem failed")
+	}
+
+	// Otherwise open a new window, unless the current one is still running.
+	_, err = l.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET window_start = :now, mints = :n, expires_at = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(user_id) OR window_start <= :cutoff"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":     number(now.Unix()),
+			":n":       number(int64(n)),
+			":expires": number(now.Add(l.window).Unix()),
+			":cutoff":  number(cutoff),
+		},
+	})
+	if err == nil {
+		return 0, nil
+	}
+	if !conditionFailed(err) {
+		return 0, errors.Wrap(err, "UpdateItem failed")
+	}
+
+	out, err := l.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "GetItem failed")
+	}
+
+	windowStart, ok := out.Item["window_start"]
+	if !ok {
+		return 0, errors.New("rate limit item disappeared")
+	}
+	start, err := strconv.ParseInt(aws.StringValue(windowStart.N), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid window_start")
+	}
+	return time.Unix(start, 0).Add(l.window).Sub(now), nil
+}
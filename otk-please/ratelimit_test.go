@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// memoryRateLimiter is a fixed-window rateLimiter kept in memory, with a
+// clock tests can move.
+type memoryRateLimiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	starts map[string]time.Time
+	mints  map[string]int
+}
+
+func newMemoryRateLimiter(max int, window time.Duration, now func() time.Time) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		max:    max,
+		window: window,
+		now:    now,
+		starts: map[string]time.Time{},
+		mints:  map[string]int{},
+	}
+}
+
+func (l *memoryRateLimiter) allow(ctx context.Context, userID string, n int) (time.Duration, error) {
+	if n > l.max {
+		return 0, &overLimitError{max: l.max}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	start, ok := l.starts[userID]
+	if !ok || !now.Before(start.Add(l.window)) {
+		l.starts[userID] = now
+		l.mints[userID] = n
+		return 0, nil
+	}
+	if l.mints[userID]+n > l.max {
+		return start.Add(l.window).Sub(now), nil
+	}
+	l.mints[userID] += n
+	return 0, nil
+}
+
+// fakeRateLimitTable evaluates the two conditional updates and the read
+// dynamoRateLimiter makes.
+type fakeRateLimitTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]int64
+}
+
+func attributeNumber(t map[string]*dynamodb.AttributeValue, name string) int64 {
+	n, _ := strconv.ParseInt(aws.StringValue(t[name].N), 10, 64)
+	return n
+}
+
+func (f *fakeRateLimitTable) UpdateItemWithContext(ctx aws.Context, in *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user := aws.StringValue(in.Key["user_id"].S)
+	item, exists := f.items[user]
+	values := in.ExpressionAttributeValues
+	failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+
+	switch aws.StringValue(in.ConditionExpression) {
+	case "window_start > :cutoff AND mints <= :room":
+		if !exists || item["window_start"] <= attributeNumber(values, ":cutoff") || item["mints"] > attributeNumber(values, ":room") {
+			return nil, failed
+		}
+		item["mints"] += attributeNumber(values, ":n")
+	case "attribute_not_exists(user_id) OR window_start <= :cutoff":
+		if exists && item["window_start"] > attributeNumber(values, ":cutoff") {
+			return nil, failed
+		}
+		f.items[user] = map[string]int64{
+			"window_start": attributeNumber(values, ":now"),
+			"mints":        attributeNumber(values, ":n"),
+			"expires_at":   attributeNumber(values, ":expires"),
+		}
+	default:
+		return nil, awserr.New("ValidationException", "unexpected condition", nil)
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeRateLimitTable) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[aws.StringValue(in.Key["user_id"].S)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	out := map[string]*dynamodb.AttributeValue{}
+	for name, n := range item {
+		out[name] = number(n)
+	}
+	return &dynamodb.GetItemOutput{Item: out}, nil
+}
+
+func TestDynamoRateLimiter(t *testing.T) {
+	table := &fakeRateLimitTable{items: map[string]map[string]int64{}}
+	l := &dynamoRateLimiter{client: table, table: "rate-limit", max: 3, window: time.Hour}
+	ctx := context.Background()
+
+	for _, n := range []int{2, 1} {
+		if wait, err := l.allow(ctx, "U0001", n); err != nil || wait != 0 {
+			t.Fatalf("allow(%v) = %v, %v; want 0, nil", n, wait, err)
+		}
+	}
+	if got := table.items["U0001"]["mints"]; got != 3 {
+		t.Fatalf("mints = %v, want 3", got)
+	}
+
+	wait, err := l.allow(ctx, "U0001", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Fatalf("wait = %v, want within the hour window", wait)
+	}
+	if got := table.items["U0001"]["mints"]; got != 3 {
+		t.Errorf("mints = %v after a limited call, want 3", got)
+	}
+
+	if wait, err := l.allow(ctx, "U0002", 1); err != nil || wait != 0 {
+		t.Errorf("another user: allow = %v, %v; want 0, nil", wait, err)
+	}
+
+	// Move the window start back past the cutoff so the next mint opens a
+	// new window.
+	table.items["U0001"]["window_start"] = time.Now().Add(-2 * time.Hour).Unix()
+	if wait, err := l.allow(ctx, "U0001", 2); err != nil || wait != 0 {
+		t.Fatalf("after rollover: allow = %v, %v; want 0, nil", wait, err)
+	}
+	if got := table.items["U0001"]["mints"]; got != 2 {
+		t.Errorf("mints = %v after rollover, want 2", got)
+	}
+}
+
+func TestDynamoRateLimiterOverLimit(t *testing.T) {
+	table := &fakeRateLimitTable{items: map[string]map[string]int64{}}
+	l := &dynamoRateLimiter{client: table, table: "rate-limit", max: 3, window: time.Hour}
+
+	_, err := l.allow(context.Background(), "U0001", 4)
+	over, ok := err.(*overLimitError)
+	if !ok || over.max != 3 {
+		t.Fatalf("err = %v, want an overLimitError with max 3", err)
+	}
+	if len(table.items) != 0 {
+		t.Errorf("recorded %v for a request over the limit", table.items)
+	}
+}
+
+func TestRateLimitedReply(t *testing.T) {
+	var mints int32
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		atomic.AddInt32(&mints, 1)
+		return Token{Value: "TOKEN1234"}, nil
+	}), nil)
+	now := time.Now()
+	a.limiter = newMemoryRateLimiter(3, time.Minute, func() time.Time { return now })
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo 3")))
+	if got := decodeResponse(t, rec).Text; !strings.Contains(got, "TOKEN1234") {
+		t.Fatalf("under the limit: reply %q has no token", got)
+	}
+
+	now = now.Add(30500 * time.Millisecond)
+	rec = httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+	if got := rec.Header().Get(errorCodeHeader); got != string(codeRateLimited) {
+		t.Errorf("%v = %q, want %q", errorCodeHeader, got, codeRateLimited)
+	}
+	if got := decodeResponse(t, rec).Text; got != "Rate limit reached, try again in 30 seconds" {
+		t.Errorf("reply = %q", got)
+	}
+	if got := atomic.LoadInt32(&mints); got != 3 {
+		t.Errorf("minted %v tokens, want 3", got)
+	}
+
+	now = now.Add(30 * time.Second)
+	rec = httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+	if got := decodeResponse(t, rec).Text; !strings.Contains(got, "TOKEN1234") {
+		t.Errorf("after rollover: reply %q has no token", got)
+	}
+}
+
+func TestOverRateLimitReply(t *testing.T) {
+	var mints int32
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		atomic.AddInt32(&mints, 1)
+		return Token{Value: "TOKEN1234"}, nil
+	}), nil)
+	a.limiter = newMemoryRateLimiter(3, time.Minute, time.Now)
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo 4")))
+
+	if got := rec.Header().Get(errorCodeHeader); got != string(codeRateLimited) {
+		t.Errorf("%v = %q, want %q", errorCodeHeader, got, codeRateLimited)
+	}
+	if got := decodeResponse(t, rec).Text; got != "You can mint at most 3 tokens at a time" {
+		t.Errorf("reply = %q", got)
+	}
+	if got := atomic.LoadInt32(&mints); got != 0 {
+		t.Errorf("minted %v tokens, want 0", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// recentErrorsSize is how many errors each instance remembers.
+	recentErrorsSize = 50
+	// defaultRecentErrors is how many the errors command shows by default.
+	defaultRecentErrors = 10
+)
+
+// recentErrors is kept per warm Lambda instance, so it only shows what this
+// instance has seen since its cold start.
+var recentErrors = &errorRing{}
+
+// errorEvent is one failed mint. It never holds token values or upstream
+// error bodies.
+type errorEvent struct {
+	at          time.Time
+	environment string
+	code        errorCode
+}
+
+// errorRing holds the last recentErrorsSize errorEvents.
+type errorRing struct {
+	mu     sync.Mutex
+	events [recentErrorsSize]errorEvent
+	next   int
+	count  int
+}
+
+// record remembers a failure with code against environment.
+func (r *errorRing) record(environment string, code errorCode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[r.next] = errorEvent{at: time.Now().UTC(), environment: environment, code: code}
+	r.next = (r.next + 1) % recentErrorsSize
+	if r.count < recentErrorsSize {
+		r.count++
+	}
+}
+
+// last returns up to n events, newest first.
+func (r *errorRing) last(n int) []errorEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > r.count {
+		n = r.count
+	}
+	events := make([]errorEvent, n)
+	for i := range events {
+		events[i] = r.events[(r.next-1-i+recentErrorsSize)%recentErrorsSize]
+	}
+	return events
+}
+
+// recentErrorsText lists the last n errors this instance recorded, for admins
+// debugging intermittent failures.
+func recentErrorsText(l locale, n int) string {
+	events := recentErrors.last(n)
+	if len(events) == 0 {
+		return l.text(msgNoRecentErrors)
+	}
+
+	lines := []string{l.text(msgRecentErrors, len(events))}
+	for _, e := range events {
+		lines = append(lines, fmt.Sprintf("`%v` *%v* %v", e.at.Format(time.RFC3339), e.environment, e.code))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// remaining returns the lowest key claim count the upstream reported across
+// tokens, or nil when it reported none.
+func remaining(tokens []Token) *int {
+	var lowest *int
+	for _, token := range tokens {
+		if token.Remaining != nil && (lowest == nil || *token.Remaining < *lowest) {
+			lowest = token.Remaining
+		}
+	}
+	return lowest
+}
+
+// expiry returns the earliest expiry across tokens, or nil when none is
+// known.
+func expiry(tokens []Token) *time.Time {
+	var earliest *time.Time
+	for _, token := range tokens {
+		if token.Expires != nil && (earliest == nil || token.Expires.Before(*earliest)) {
+			earliest = token.Expires
+		}
+	}
+	return earliest
+}
+
+// formatExpiry renders expires relative to now, e.g. "expires in 24h", along
+// with the absolute time in UTC.
+func formatExpiry(l locale, expires time.Time) string {
+	in := time.Until(expires)
+	relative := fmt.Sprintf("%vh", int(in.Round(time.Hour).Hours()))
+	if in < time.Hour {
+		relative = fmt.Sprintf("%vm", int(in.Round(time.Minute).Minutes()))
+	}
+	return l.text(msgExpires, relative, expires.UTC().Format("2006-01-02 15:04 MST"))
+}
+
+// userName names the requesting user, falling back to their ID when Slack
+// sends no user name.
+func userName(s slack.SlashCommand) string {
+	if s.UserName == "" {
+		return s.UserID
+	}
+	return s.UserName
+}
+
+// userLabel refers to the requesting user in a reply, e.g. "@alice", or
+// mentions their ID when Slack sends no user name.
+func userLabel(s slack.SlashCommand) string {
+	if s.UserName == "" {
+		return fmt.Sprintf("<@%v>", s.UserID)
+	}
+	return "@" + s.UserName
+}
+
+// tokenText renders token's value, in a code span when code is set so it
+// copies cleanly, followed by its wrapped form when there is one. The raw
+// value is always shown on its own.
+func tokenText(token Token, code bool) string {
+	text := token.Value
+	if code {
+		text = fmt.Sprintf("`%v`", token.Value)
+	}
+	if token.Wrapped == "" {
+		return text
+	}
+	return fmt.Sprintf("%v (%v)", text, token.Wrapped)
+}
+
+// formatTokens renders a single token inline and several as a numbered list,
+// naming user, followed by the remaining key claim count and expiry when
+// known.
+func formatTokens(l locale, environment, user string, tokens []Token) string {
+	var b strings.Builder
+	if len(tokens) == 1 {
+		b.WriteString(l.text(msgToken, environment, user, tokenText(tokens[0], false)))
+	} else {
+		b.WriteString(l.text(msgTokens, environment, user))
+		for i, token := range tokens {
+			fmt.Fprintf(&b, "\n%v. %v", i+1, tokenText(token, false))
+		}
+	}
+
+	if n := remaining(tokens); n != nil {
+		fmt.Fprintf(&b, " %v", l.text(msgRemaining, *n))
+	}
+	if expires := expiry(tokens); expires != nil {
+		fmt.Fprintf(&b, " %v", formatExpiry(l, *expires))
+	}
+	return b.String()
+}
+
+// tokenJSON is the machine-readable form of a minted token.
+type tokenJSON struct {
+	Environment string     `json:"environment"`
+	Token       string     `json:"token"`
+	Remaining   *int       `json:"remaining,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Wrapped     string     `json:"wrapped,omitempty"`
+}
+
+// formatJSON renders tokens as one JSON object per line inside a code block,
+// for scripts that drive the command.
+func formatJSON(environment string, tokens []Token) string {
+	var b strings.Builder
+	b.WriteString("```\n")
+	for _, token := range tokens {
+		line, _ := json.Marshal(tokenJSON{
+			Environment: environment,
+			Token:       token.Value,
+			Remaining:   token.Remaining,
+			ExpiresAt:   token.Expires,
+			Wrapped:     token.Wrapped,
+		})
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// buildTokenBlocks lays out minted tokens as a header naming the environment
+// followed by a code-formatted section per token and a note naming user.
+func buildTokenBlocks(l locale, environment, user string, tokens ...Token) slack.Msg {
+	title := l.text(msgTokenTitle, environment)
+	if len(tokens) > 1 {
+		title = l.text(msgTokensTitle, environment)
+	}
+
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, title, true, false)),
+	}
+	for i, token := range tokens {
+		text := tokenText(token, true)
+		if len(tokens) > 1 {
+			text = fmt.Sprintf("%v. %v", i+1, text)
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+	}
+	notes := []slack.MixedElement{
+		slack.NewTextBlockObject(slack.MarkdownType, l.text(msgRequestedBy, user), false, false),
+	}
+	if n := remaining(tokens); n != nil {
+		notes = append(notes, slack.NewTextBlockObject(slack.PlainTextType, l.text(msgRemaining, *n), false, false))
+	}
+	if expires := expiry(tokens); expires != nil {
+		notes = append(notes, slack.NewTextBlockObject(slack.PlainTextType, formatExpiry(l, *expires), false, false))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", notes...))
+
+	return slack.Msg{
+		Text:   formatTokens(l, environment, user, tokens),
+		Blocks: slack.Blocks{BlockSet: blocks},
+	}
+}
+
+// mintAnotherAction prefixes the action_id of the "Mint another" button; the
+// environment key follows it.
+const mintAnotherAction = "mint_another:"
+
+// mintAnotherBlock builds the "Mint another" button shown under minted tokens.
+// Its value carries the original command text so a press repeats it.
+func mintAnotherBlock(l locale, environment, text string) *slack.ActionBlock {
+	button := slack.NewButtonBlockElement(mintAnotherAction+environment, text,
+		slack.NewTextBlockObject(slack.PlainTextType, l.text(msgMintAnother), false, false))
+	return slack.NewActionBlock("", button)
+}
+
+// respond writes msg as the slash command response payload, with its error
+// code in errorCodeHeader when it reports a failure.
+func respond(w http.ResponseWriter, msg response) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setErrorCode(w, msg.ErrorCode)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// writeError sends a JSON error document with status and code. message must
+// not include secrets or signatures.
+func writeError(w http.ResponseWriter, status int, code errorCode, message string) {
+	body, _ := json.Marshal(struct {
+		Error string    `json:"error"`
+		Code  errorCode `json:"error_code"`
+	}{message, code})
+
+	setErrorCode(w, code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// ephemeral builds a message that only the invoking user can see.
+func ephemeral(text string) response {
+	return response{Msg: slack.Msg{ResponseType: slack.ResponseTypeEphemeral, Text: text}}
+}
+
+// inThread returns r as a reply in the thread with timestamp ts. An empty ts
+// leaves r as a normal channel reply.
+func (r response) inThread(ts string) response {
+	r.ThreadTimestamp = ts
+	return r
+}
+
+// defaultResponseTimeout bounds a response_url POST unless
+// RESPONSE_TIMEOUT_SECONDS overrides it.
+const defaultResponseTimeout = 3 * time.Second
+
+// postResponse delivers msg to a slash command or interaction response_url.
+// It runs under its own timeout so a slow Slack can't hold the invocation
+// open.
+func postResponse(ctx context.Context, url string, msg response) error {
+	ctx, cancel := context.WithTimeout(ctx, envDuration("RESPONSE_TIMEOUT_SECONDS", defaultResponseTimeout))
+	defer cancel()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "Marshal failed")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("response_url returned %v", res.StatusCode)
+	}
+	return nil
+}
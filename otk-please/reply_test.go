@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostResponse(t *testing.T) {
+	var got response
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	if err := postResponse(context.Background(), srv.URL, ephemeral("hello")); err != nil {
+		t.Fatalf("postResponse() = %v", err)
+	}
+	if got.Text != "hello" || got.ResponseType != "ephemeral" {
+		t.Errorf("posted %+v", got.Msg)
+	}
+}
+
+func TestPostResponseFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout time.Duration
+	}{
+		{
+			name: "error status",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+		},
+		{
+			name: "too slow",
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				select {
+				case <-req.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			timeout: 50 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if err := postResponse(ctx, srv.URL, ephemeral("hello")); err == nil {
+				t.Error("postResponse() succeeded")
+			}
+		})
+	}
+}
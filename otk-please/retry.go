@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultMaxRetries is used when MAX_RETRIES is unset.
+	defaultMaxRetries = 3
+	retryBaseDelay    = 100 * time.Millisecond
+	retryMaxDelay     = 2 * time.Second
+)
+
+// maxRetries is the Config's MaxRetries, set by configureUpstream.
+var maxRetries = defaultMaxRetries
+
+// errUpstreamBusy is returned when the upstream asks us to wait longer than
+// the invocation has left.
+var errUpstreamBusy = errors.New("upstream is busy")
+
+// retryable reports whether a getToken error is worth another attempt: 5xx
+// and 429 responses and network errors are, other 4xx responses and
+// cancellation are not.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Retrying would use up another key claim for the same bad answer.
+	if errors.Is(err, errMalformedToken) {
+		return false
+	}
+	// An oversized answer will be just as large next time.
+	if errors.Is(err, errResponseTooLarge) {
+		return false
+	}
+	// A bad certificate won't fix itself between attempts.
+	if isCertificateError(err) {
+		return false
+	}
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.statusCode >= 500 || upErr.statusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// backoff returns a random delay up to an exponentially growing cap ("full
+// jitter") so concurrent retries don't line up.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// getTokenWithRetry calls getToken, retrying transient failures up to
+// MAX_RETRIES times while the instance's retryBudget lasts. It stops early
+// once ctx is done and returns the last error if every attempt fails.
+func getTokenWithRetry(ctx context.Context, env environment, bearerToken string) (Token, error) {
+	retries := maxRetries
+	for attempt := 0; ; attempt++ {
+		var token Token
+		err := trace(ctx, "upstream", func(ctx context.Context) error {
+			var err error
+			token, err = getToken(ctx, env, bearerToken)
+			return err
+		})
+		if err == nil || !retryable(err) || attempt >= retries {
+			return token, err
+		}
+		if !retryBudget.take() {
+			logger.Warn("retry budget exhausted, not retrying", "request_id", requestID(ctx), "environment", env.name)
+			return token, err
+		}
+
+		// Honour the upstream's Retry-After over our own backoff, unless
+		// waiting would run past the deadline.
+		delay := backoff(attempt)
+		var upErr *upstreamError
+		if errors.As(err, &upErr) && upErr.retryAfter > 0 {
+			delay = upErr.retryAfter
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+				return Token{}, errors.Wrapf(errUpstreamBusy, "asked to retry after %v: %v", delay, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return Token{}, err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// revokeToken asks env's upstream to invalidate token, signing the request
+// like a mint when env has a signingKey.
+func revokeToken(ctx context.Context, env environment, bearerToken, token string) error {
+	revokeURL := env.baseURL + env.revokePath
+	if err := checkUpstreamHost(revokeURL); err != nil {
+		return err
+	}
+
+	ctx, cancel := upstreamContext(ctx)
+	defer cancel()
+
+	body, err := json.Marshal(struct {
+		Token string `json:"token"`
+	}{token})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	env.setHeaders(req)
+	env.authorize(req, bearerToken)
+	env.sign(req, body, time.Now())
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := upstreamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodyLength))
+		return &upstreamError{statusCode: res.StatusCode, body: string(body)}
+	}
+	return nil
+}
+
+// revoke answers a revoke command that has passed the same checks as a mint.
+// The token itself is never logged, only its fingerprint.
+func (a *app) revoke(ctx context.Context, log *slog.Logger, env environment, cmd command, bearerToken string) response {
+	if env.revokePath == "" {
+		return failure(codeInvalidCommand, cmd.locale.text(msgRevokeUnavailable))
+	}
+
+	log = log.With("fingerprint", a.config.Fingerprint.fingerprint(cmd.token))
+	env, err := withSigningKey(ctx, a.secrets, env)
+	if err != nil {
+		log.Error("could not load signing key", "error", err)
+		return failure(codeInternalError, cmd.locale.text(msgNoCredentials, env.name))
+	}
+	if err := revokeToken(ctx, env, bearerToken, cmd.token); err != nil {
+		log.Error("could not revoke token", "upstream_status", upstreamStatus(err), "error", err)
+		return failure(codeUpstreamError, cmd.locale.text(msgRevokeFailed, env.name))
+	}
+
+	log.Info("revoked token")
+	return ephemeral(cmd.locale.text(msgRevoked, env.name))
+}
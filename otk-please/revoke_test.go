@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokeIsSigned(t *testing.T) {
+	var path, signature, timestamp string
+	var body []byte
+	upstream := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		signature = req.Header.Get(defaultSignatureHeader)
+		timestamp = req.Header.Get(defaultSignatureTimestampHeader)
+		body, _ = ioutil.ReadAll(req.Body)
+	})
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{
+		"DEMO_URL":         upstream.baseURL,
+		"DEMO_REVOKE_PATH": "/revoke-key-claim",
+		"DEMO_SIGNING_KEY": "DEMO_HMAC",
+		"DEMO_HMAC":        "hmac-key",
+	})
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "revoke demo AbCd1234")))
+
+	if msg := decodeResponse(t, rec); msg.Text != english.text(msgRevoked, "Demo") {
+		t.Fatalf("reply = %q, want the revoked message", msg.Text)
+	}
+	if path != "/revoke-key-claim" {
+		t.Errorf("revoke path = %q, want the override", path)
+	}
+	mac := hmac.New(sha256.New, []byte("hmac-key"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("%v = %q, want %q", defaultSignatureHeader, signature, want)
+	}
+}
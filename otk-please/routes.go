@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// What a slash command can be routed to by SLASH_COMMANDS.
+const (
+	routeMint = "mint"
+	routeList = "list"
+)
+
+var knownRoutes = []string{routeMint, routeList}
+
+// commandRoutes maps each slash command this function answers, e.g.
+// "/please", to its route. Empty means every command mints, as before
+// several commands could share the function.
+type commandRoutes map[string]string
+
+// parseCommandRoutes reads SLASH_COMMANDS, a JSON object such as
+// {"/please": "mint", "/tokens": "list"}.
+func parseCommandRoutes(value string) (commandRoutes, error) {
+	if value == "" {
+		return commandRoutes{}, nil
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return nil, errors.New("SLASH_COMMANDS must be a JSON object of slash command to route")
+	}
+	routes := make(commandRoutes, len(parsed))
+	for command, route := range parsed {
+		if !strings.HasPrefix(command, "/") {
+			return nil, errors.Errorf("SLASH_COMMANDS has %q, which doesn't start with /", command)
+		}
+		if !contains(knownRoutes, route) {
+			return nil, errors.Errorf("SLASH_COMMANDS routes %v to unknown %q, expected one of %v", command, route, strings.Join(knownRoutes, ", "))
+		}
+		routes[strings.ToLower(command)] = route
+	}
+	return routes, nil
+}
+
+// route returns the route for slashCommand, or "" when it isn't one of
+// these. Interaction replays carry no command and always mint.
+func (r commandRoutes) route(slashCommand string) string {
+	if len(r) == 0 || slashCommand == "" {
+		return routeMint
+	}
+	return r[strings.ToLower(slashCommand)]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCommandRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  string
+		command string
+		minted  bool
+		want    string
+	}{
+		{name: "unrouted mints", command: "/please", minted: true, want: "TOKEN1234"},
+		{name: "mint", routes: `{"/please": "mint", "/tokens": "list"}`, command: "/please", minted: true, want: "TOKEN1234"},
+		{name: "list", routes: `{"/please": "mint", "/tokens": "list"}`, command: "/tokens", want: english.text(msgListTitle)},
+		{name: "case-insensitive", routes: `{"/please": "mint", "/tokens": "list"}`, command: "/TOKENS", want: english.text(msgListTitle)},
+		{name: "unknown", routes: `{"/please": "mint"}`, command: "/other", want: english.text(msgUnknownCommand, "/other")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minted := false
+			a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+				minted = true
+				return Token{Value: "TOKEN1234"}, nil
+			}), map[string]string{"SLASH_COMMANDS": tt.routes})
+
+			form := slashForm("U0001", "demo")
+			form.Set("command", tt.command)
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(form))
+
+			if msg := decodeResponse(t, rec); !strings.Contains(msg.Text, tt.want) {
+				t.Errorf("reply = %q, want it to contain %q", msg.Text, tt.want)
+			}
+			if minted != tt.minted {
+				t.Errorf("minted = %v, want %v", minted, tt.minted)
+			}
+		})
+	}
+}
+
+func TestParseCommandRoutesInvalid(t *testing.T) {
+	for _, value := range []string{`["/please"]`, `{"please": "mint"}`, `{"/please": "revoke"}`} {
+		if _, err := parseCommandRoutes(value); err == nil {
+			t.Errorf("parseCommandRoutes(%q) succeeded, want an error", value)
+		}
+	}
+}
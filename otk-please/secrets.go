@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/pkg/errors"
+)
+
+// secretsProvider resolves a secret name from the environment registry to
+// its value.
+type secretsProvider interface {
+	secret(ctx context.Context, name string) (string, error)
+}
+
+// envSecrets reads secrets from environment variables of the same name.
+type envSecrets struct{}
+
+func (envSecrets) secret(ctx context.Context, name string) (string, error) {
+	return os.Getenv(name), nil
+}
+
+// secretsManagerSecrets reads secrets from AWS Secrets Manager by name.
+type secretsManagerSecrets struct {
+	client secretsmanageriface.SecretsManagerAPI
+}
+
+func (s secretsManagerSecrets) secret(ctx context.Context, name string) (string, error) {
+	out, err := s.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "GetSecretValue %v failed", name)
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// fileSecretPrefix marks a secret name as the path of a mounted file, e.g.
+// file:/run/secrets/demo, instead of a name in the secrets backend.
+const fileSecretPrefix = "file:"
+
+// fileSecrets reads secrets from mounted files. Names must carry
+// fileSecretPrefix.
+type fileSecrets struct{}
+
+func (fileSecrets) secret(ctx context.Context, name string) (string, error) {
+	path := strings.TrimPrefix(name, fileSecretPrefix)
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read secret file %v", path)
+	}
+	return strings.TrimSuffix(string(value), "\n"), nil
+}
+
+// routedSecrets reads file: names with files and every other name with
+// provider, so mounted files work alongside either backend.
+type routedSecrets struct {
+	files    secretsProvider
+	provider secretsProvider
+}
+
+func (r routedSecrets) secret(ctx context.Context, name string) (string, error) {
+	if strings.HasPrefix(name, fileSecretPrefix) {
+		return r.files.secret(ctx, name)
+	}
+	return r.provider.secret(ctx, name)
+}
+
+// cachedSecrets remembers every secret it successfully fetches for the
+// lifetime of the Lambda instance.
+type cachedSecrets struct {
+	provider secretsProvider
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newCachedSecrets(provider secretsProvider) *cachedSecrets {
+	return &cachedSecrets{provider: provider, values: map[string]string{}}
+}
+
+func (c *cachedSecrets) secret(ctx context.Context, name string) (string, error) {
+	c.mu.Lock()
+	value, ok := c.values[name]
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	value, err := c.provider.secret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.values[name] = value
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+// newSecretsProvider picks the backend from SECRETS_BACKEND. Secrets Manager
+// is the default; "env" falls back to plain environment variables. Names
+// starting with fileSecretPrefix are read from files with either backend.
+func newSecretsProvider(sess *session.Session) secretsProvider {
+	files := newCachedSecrets(fileSecrets{})
+	if os.Getenv("SECRETS_BACKEND") == "env" {
+		return routedSecrets{files: files, provider: envSecrets{}}
+	}
+
+	client := secretsmanager.New(sess)
+	return routedSecrets{files: files, provider: newCachedSecrets(secretsManagerSecrets{client: client})}
+}
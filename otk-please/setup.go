@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// maxSetupBodyBytes bounds how much of a request is read to spot setup
+// requests.
+const maxSetupBodyBytes = 64 << 10
+
+// peekBody reads req's body and puts it back for later readers.
+func peekBody(req *http.Request) []byte {
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, maxSetupBodyBytes))
+	req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+	return body
+}
+
+// answerSSLCheck answers the ssl_check=1 request Slack sends, unsigned, when
+// a slash command URL is saved. It only needs a 200 and does nothing else,
+// so it can safely come before verification.
+func answerSSLCheck(w http.ResponseWriter, req *http.Request) bool {
+	form, err := url.ParseQuery(string(peekBody(req)))
+	if err != nil || form.Get("ssl_check") != "1" {
+		return false
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// answerURLVerification echoes the challenge of a verified url_verification
+// request, which Slack sends when a request URL is configured.
+func answerURLVerification(w http.ResponseWriter, req *http.Request) bool {
+	var event struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(peekBody(req), &event); err != nil || event.Type != "url_verification" {
+		return false
+	}
+
+	body, _ := json.Marshal(struct {
+		Challenge string `json:"challenge"`
+	}{event.Challenge})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+	return true
+}
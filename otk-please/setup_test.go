@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSLCheck(t *testing.T) {
+	minted := false
+	a := testApp(t, stubSource(func(ctx context.Context, env environment) (Token, error) {
+		minted = true
+		return Token{Value: "TOKEN1234"}, nil
+	}), nil)
+
+	// Slack doesn't sign ssl_check requests.
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ssl_check=1&token=legacy"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.handler(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("ssl_check = %v %q, want an empty 200", rec.Code, rec.Body)
+	}
+	if minted {
+		t.Error("ssl_check minted a token")
+	}
+}
+
+func TestURLVerification(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), nil)
+	body := `{"type": "url_verification", "token": "legacy", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`
+
+	t.Run("signed", func(t *testing.T) {
+		req := signedRequest("/", testSigningSecret, time.Now(), body)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handler(rec, req)
+
+		var doc struct {
+			Challenge string `json:"challenge"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+		}
+		if doc.Challenge != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+			t.Errorf("challenge = %q, want it echoed", doc.Challenge)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		req := signedRequest("/", "not-the-secret", time.Now(), body)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handler(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %v, want 401", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "3eZbrw1a") {
+			t.Error("echoed the challenge of an unverified request")
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSignatureHeader carries the HMAC of a signed upstream request.
+	defaultSignatureHeader = "X-Signature"
+	// defaultSignatureTimestampHeader carries the Unix time that was signed.
+	defaultSignatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// signingAlgorithms are the hashes signingAlgorithm may name; empty means
+// sha256.
+var signingAlgorithms = map[string]func() hash.Hash{
+	"":       sha256.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// validateSigning checks e's signing algorithm and header names. Environments
+// without a signingKeyName aren't signed and always pass.
+func (e environment) validateSigning() error {
+	if e.signingKeyName == "" {
+		return nil
+	}
+	if _, ok := signingAlgorithms[e.signingAlgorithm]; !ok {
+		return errors.Errorf("signing algorithm must be sha256 or sha512, got %q", e.signingAlgorithm)
+	}
+	for _, name := range []string{e.signatureHeader, e.signatureTimestampHeader} {
+		if name != "" && (!validHeaderName.MatchString(name) || containsFold(reservedHeaders, name)) {
+			return errors.Errorf("invalid signature header %q", name)
+		}
+	}
+	return nil
+}
+
+// withSigningKey returns env with its signingKey loaded from secrets, or env
+// unchanged when it isn't signed. Failures are credentialsErrors, like a
+// missing bearer token.
+func withSigningKey(ctx context.Context, secrets secretsProvider, env environment) (environment, error) {
+	if env.signingKeyName == "" {
+		return env, nil
+	}
+	key, err := secrets.secret(ctx, env.signingKeyName)
+	if err != nil {
+		return env, &credentialsError{err: errors.Wrap(err, "could not load signing key")}
+	}
+	if key == "" {
+		return env, &credentialsError{err: errors.New("signing key is empty")}
+	}
+	env.signingKey = key
+	return env, nil
+}
+
+// sign adds the hex HMAC of "<timestamp>.<body>" under e's signingKey, and
+// the timestamp itself, to req. It does nothing when e has no signingKey.
+func (e environment) sign(req *http.Request, body []byte, now time.Time) {
+	if e.signingKey == "" {
+		return
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(signingAlgorithms[e.signingAlgorithm], []byte(e.signingKey))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	signatureHeader, timestampHeader := e.signatureHeader, e.signatureTimestampHeader
+	if signatureHeader == "" {
+		signatureHeader = defaultSignatureHeader
+	}
+	if timestampHeader == "" {
+		timestampHeader = defaultSignatureTimestampHeader
+	}
+	req.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(timestampHeader, timestamp)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignKnownVectors(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		env       environment
+		body      string
+		header    string
+		timestamp string
+		want      string
+	}{
+		{
+			name:      "sha256",
+			body:      `{"token":"AbCd1234"}`,
+			header:    defaultSignatureHeader,
+			timestamp: defaultSignatureTimestampHeader,
+			want:      "863ad321a62ce88d741f354a15f1be4a181a4d0aca9074b30289cf7a7fabd6a7",
+		},
+		{
+			name:      "sha512",
+			env:       environment{signingAlgorithm: "sha512"},
+			body:      `{"token":"AbCd1234"}`,
+			header:    defaultSignatureHeader,
+			timestamp: defaultSignatureTimestampHeader,
+			want:      "678e5c51ea7232517f14fccc9f078998da3b58d01fbc4da182f2c12f4b7d2e945af6dda2c0d781cd70349495f859381e5ec290a879ae4106018ec50bcb6fb3fb",
+		},
+		{
+			name:      "empty body and custom headers",
+			env:       environment{signatureHeader: "X-Otk-Signature", signatureTimestampHeader: "X-Otk-Timestamp"},
+			header:    "X-Otk-Signature",
+			timestamp: "X-Otk-Timestamp",
+			want:      "c868da6b6f3e30b87f29073852a8861eb546bba4e82743f9a860e5dd70e7aaee",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			env.signingKeyName = "SIGNING_KEY"
+			env.signingKey = "upstream-signing-key"
+			if err := env.validateSigning(); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			env.sign(req, []byte(tt.body), now)
+
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.header, got, tt.want)
+			}
+			if got := req.Header.Get(tt.timestamp); got != "1700000000" {
+				t.Errorf("%v = %q, want 1700000000", tt.timestamp, got)
+			}
+		})
+	}
+}
+
+func TestSignWithoutKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	environment{}.sign(req, []byte("body"), time.Now())
+	if req.Header.Get(defaultSignatureHeader) != "" {
+		t.Error("signed a request for an environment without a signing key")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"time"
+)
+
+// newTokenSource mints over HTTP with the bearer tokens in secrets. Local
+// HTTP runs can set TOKEN_SOURCE=fake to mint without an upstream; it is
+// ignored on Lambda so a deployment can never hand out made-up tokens.
+func newTokenSource(secrets secretsProvider) TokenSource {
+	if httpMode() && os.Getenv("TOKEN_SOURCE") == "fake" {
+		logger.Warn("minting fake tokens")
+		return fakeSource{}
+	}
+	return httpSource{secrets: secrets}
+}
+
+// fakeTokenAlphabet is what fake tokens are made of, like real ones.
+const fakeTokenAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// fakeTokenLength matches the length of real key-claim tokens.
+const fakeTokenLength = 10
+
+// fakeSource mints random tokens without any network call. The tokens are
+// not valid anywhere.
+type fakeSource struct{}
+
+func (fakeSource) Mint(ctx context.Context, env environment) (Token, error) {
+	value := make([]byte, fakeTokenLength)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(fakeTokenAlphabet))))
+		if err != nil {
+			return Token{}, err
+		}
+		value[i] = fakeTokenAlphabet[n.Int64()]
+	}
+
+	token := Token{Value: string(value)}
+	if env.tokenTTL > 0 {
+		expires := time.Now().Add(env.tokenTTL)
+		token.Expires = &expires
+	}
+	return token, nil
+}
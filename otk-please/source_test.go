@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeSourceMint(t *testing.T) {
+	env := environment{name: "Demo", tokenTTL: time.Hour, tokenFormat: regexp.MustCompile(defaultTokenPattern)}
+
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		token, err := fakeSource{}.Mint(context.Background(), env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token.Value) != fakeTokenLength || strings.Trim(token.Value, fakeTokenAlphabet) != "" {
+			t.Errorf("Mint() = %q, want %v characters of %q", token.Value, fakeTokenLength, fakeTokenAlphabet)
+		}
+		// Fake tokens must pass the same checks as real ones.
+		if err := env.validateToken(token.Value); err != nil {
+			t.Errorf("validateToken(%q) = %v", token.Value, err)
+		}
+		if token.Expires == nil || time.Until(*token.Expires) > time.Hour {
+			t.Errorf("Expires = %v, want within the hour", token.Expires)
+		}
+		seen[token.Value] = true
+	}
+	if len(seen) < 20 {
+		t.Errorf("minted %v distinct tokens out of 20", len(seen))
+	}
+}
+
+func TestNewTokenSource(t *testing.T) {
+	t.Setenv("TOKEN_SOURCE", "fake")
+
+	t.Setenv("RUN_MODE", "")
+	if _, ok := newTokenSource(envSecrets{}).(httpSource); !ok {
+		t.Error("Lambda minted with a fake source")
+	}
+
+	t.Setenv("RUN_MODE", "http")
+	if _, ok := newTokenSource(envSecrets{}).(fakeSource); !ok {
+		t.Error("TOKEN_SOURCE=fake did not pick the fake source in HTTP mode")
+	}
+}
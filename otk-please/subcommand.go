@@ -0,0 +1,44 @@
+package main
+
+// subcommands are the words that run something other than a mint, checked
+// for typos before a word is reported as an unknown environment.
+var subcommands = []string{"help", "version", "whoami", "list", "envs", "errors", "config-check", "revoke", "audit"}
+
+// suggestSubcommand returns the subcommand word is most likely a typo of, or
+// "" when none is close. Short words need a closer match so that, say, an
+// environment key isn't mistaken for one.
+func suggestSubcommand(word string) string {
+	limit := 2
+	if len(word) <= 4 {
+		limit = 1
+	}
+
+	best, bestDistance := "", limit+1
+	for _, sub := range subcommands {
+		if d := editDistance(word, sub); d < bestDistance {
+			best, bestDistance = sub, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b, counting bytes.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
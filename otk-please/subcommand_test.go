@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSuggestSubcommand(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{word: "helpp", want: "help"},
+		{word: "hel", want: "help"},
+		{word: "whomai", want: "whoami"},
+		{word: "verison", want: "version"},
+		{word: "config-chek", want: "config-check"},
+		{word: "revok", want: "revoke"},
+		{word: "lst", want: "list"},
+		// Short words must be a single edit away, so a swap is too far.
+		{word: "hlep", want: ""},
+		{word: "dev", want: ""},
+		{word: "nowhere", want: ""},
+	}
+	for _, tt := range tests {
+		if got := suggestSubcommand(tt.word); got != tt.want {
+			t.Errorf("suggestSubcommand(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), nil)
+
+	rec := httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "whomai")))
+
+	msg := decodeResponse(t, rec)
+	if msg.Text != english.text(msgDidYouMean, "whoami") || msg.ErrorCode != codeInvalidCommand {
+		t.Errorf("reply = %q (%v), want a whoami suggestion", msg.Text, msg.ErrorCode)
+	}
+
+	// A real environment is never mistaken for a typo.
+	rec = httptest.NewRecorder()
+	a.handler(rec, slashRequest(slashForm("U0001", "demo")))
+	if msg := decodeResponse(t, rec); msg.ErrorCode != "" {
+		t.Errorf("demo reply = %q (%v), want a token", msg.Text, msg.ErrorCode)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// replyData is what an environment's reply template is rendered with.
+type replyData struct {
+	// Token is the first token and Tokens every token minted
+	Token  string
+	Tokens []string
+	// Wrapped is the first token rendered with the environment's
+	// tokenWrapper, or empty when it has none
+	Wrapped string
+	// Environment is the display name, e.g. "Production"
+	Environment string
+	// User refers to the requesting user, e.g. "@alice"
+	User string
+	// Expires describes when the tokens expire, or is empty when unknown
+	Expires string
+}
+
+// parseReplyTemplate parses text and renders it once against sample data, so
+// a template that refers to a missing field fails at startup rather than on a
+// user's command.
+func parseReplyTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid reply template for %v", name)
+	}
+
+	expires := time.Now().Add(24 * time.Hour)
+	sample := []Token{{Value: "0123456789", Expires: &expires}}
+	if _, err := renderReply(t, english, name, "@user", sample); err != nil {
+		return nil, errors.Wrapf(err, "invalid reply template for %v", name)
+	}
+	return t, nil
+}
+
+// parseTokenWrapper parses text and renders it once against a sample token.
+func parseTokenWrapper(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid token wrapper for %v", name)
+	}
+	if _, err := wrapToken(t, "0123456789"); err != nil {
+		return nil, errors.Wrapf(err, "invalid token wrapper for %v", name)
+	}
+	return t, nil
+}
+
+// wrapToken renders t around value.
+func wrapToken(t *template.Template, value string) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, struct{ Token string }{value}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderReply renders t for tokens minted against environment by user.
+func renderReply(t *template.Template, l locale, environment, user string, tokens []Token) (string, error) {
+	data := replyData{Environment: environment, User: user}
+	for _, token := range tokens {
+		data.Tokens = append(data.Tokens, token.Value)
+	}
+	if len(tokens) > 0 {
+		data.Token, data.Wrapped = tokens[0].Value, tokens[0].Wrapped
+	}
+	if expires := expiry(tokens); expires != nil {
+		data.Expires = formatExpiry(l, *expires)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
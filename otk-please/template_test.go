@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderReply(t *testing.T) {
+	tokens := []Token{{Value: "TOKEN-ONE", Wrapped: "<https://example.com/TOKEN-ONE|TOKEN-ONE>"}, {Value: "TOKEN-TWO"}}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "first token", template: "{{.Environment}}: {{.Token}}", want: "Demo: TOKEN-ONE"},
+		{name: "every token", template: "{{range .Tokens}}{{.}} {{end}}", want: "TOKEN-ONE TOKEN-TWO "},
+		{name: "user", template: "{{.User}} asked for {{len .Tokens}}", want: "@alice asked for 2"},
+		{name: "wrapped", template: "{{.Wrapped}}", want: "<https://example.com/TOKEN-ONE|TOKEN-ONE>"},
+		{name: "no expiry", template: "{{if .Expires}}expires{{else}}no expiry{{end}}", want: "no expiry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseReplyTemplate("Demo", tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := renderReply(tmpl, english, "Demo", "@alice", tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("renderReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReplyTemplateInvalid(t *testing.T) {
+	for _, text := range []string{"{{.Token", "{{.Missing}}"} {
+		if _, err := parseReplyTemplate("Demo", text); err == nil || !strings.Contains(err.Error(), "invalid reply template for Demo") {
+			t.Errorf("parseReplyTemplate(%q) = %v, want an invalid template error", text, err)
+		}
+	}
+}
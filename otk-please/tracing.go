@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// tracingEnabled reports whether TRACING_ENABLED turns on X-Ray. It needs
+// active tracing on the Lambda too: the SDK panics when there is no segment
+// to attach subsegments to, so nothing touches it unless this is set.
+func tracingEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("TRACING_ENABLED"))
+	return err == nil && enabled
+}
+
+// trace runs fn in an X-Ray subsegment called name, or just runs it when
+// tracing is off.
+func trace(ctx context.Context, name string, fn func(context.Context) error) error {
+	if !tracingEnabled() {
+		return fn(ctx)
+	}
+	return xray.Capture(ctx, name, fn)
+}
+
+// traceClient instruments c so each request is recorded and carries the trace
+// header to the server, when tracing is on.
+func traceClient(c *http.Client) *http.Client {
+	if !tracingEnabled() {
+		return c
+	}
+	return xray.Client(c)
+}
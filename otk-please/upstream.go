@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Token is a minted key-claim token.
+type Token struct {
+	Value string
+	// Remaining is how many key claims the upstream reports are left, or nil
+	// when it doesn't say
+	Remaining *int
+	// Expires is when the token stops being valid, or nil when unknown
+	Expires *time.Time
+	// Wrapped is Value rendered with the environment's tokenWrapper, e.g. as
+	// a link, or empty when it has none
+	Wrapped string
+}
+
+// TokenSource mints a single key-claim token against an environment. The
+// handler only depends on this, so other backends can replace HTTP.
+type TokenSource interface {
+	Mint(ctx context.Context, env environment) (Token, error)
+}
+
+// httpSource mints tokens from the environment's submission server using the
+// bearer token held in secrets.
+type httpSource struct {
+	secrets secretsProvider
+}
+
+// Mint tries the primary bearer token first and falls back to the
+// secondary one when the upstream rejects the primary with 401 or 403. It
+// refuses hosts off the allowlist and fails fast with errCircuitOpen while the
+// environment's upstream is down.
+func (m httpSource) Mint(ctx context.Context, env environment) (Token, error) {
+	if err := checkUpstreamHost(env.tokenURL()); err != nil {
+		logger.Error("refusing upstream request", "request_id", requestID(ctx), "environment", env.name, "error", err)
+		return Token{}, err
+	}
+
+	if !breaker.allow(env.name) {
+		return Token{}, errCircuitOpen
+	}
+
+	token, err := m.mintToken(ctx, env)
+	breaker.record(env.name, err)
+	return token, err
+}
+
+func (m httpSource) mintToken(ctx context.Context, env environment) (Token, error) {
+	bearerToken, err := m.secrets.secret(ctx, env.secretName)
+	if err != nil {
+		return Token{}, &credentialsError{err: err}
+	}
+	if env, err = withSigningKey(ctx, m.secrets, env); err != nil {
+		return Token{}, err
+	}
+
+	token, err := getTokenWithRetry(ctx, env, bearerToken)
+	if !rejected(err) || env.secondarySecretName == "" {
+		if err == nil {
+			logCredential(ctx, env, "primary")
+		}
+		return token, err
+	}
+
+	secondary, secErr := m.secrets.secret(ctx, env.secondarySecretName)
+	if secErr != nil || secondary == "" {
+		return Token{}, err
+	}
+
+	token, err = getTokenWithRetry(ctx, env, secondary)
+	if err == nil {
+		logCredential(ctx, env, "secondary")
+	}
+	return token, err
+}
+
+// defaultUpstreamHosts are the domain suffixes upstream requests may go to
+// unless ALLOWED_UPSTREAM_HOSTS overrides them. Production lives outside the
+// sandbox domain, so it is listed too.
+var defaultUpstreamHosts = []string{"cdssandbox.xyz", "covid-notification.alpha.canada.ca"}
+
+// allowedUpstreamHosts is the Config's AllowedUpstreamHosts, set by
+// configureUpstream.
+var allowedUpstreamHosts = defaultUpstreamHosts
+
+// configureUpstream applies config to the state shared by every upstream
+// call on this instance: the host allowlist, response cap, retries, retry
+// budget and circuit breaker.
+func configureUpstream(config Config) {
+	allowedUpstreamHosts = config.AllowedUpstreamHosts
+	maxResponseBytes = config.MaxResponseBytes
+	maxRetries = config.MaxRetries
+	retryBudget = newRetryBudget(config.RetryBudget)
+	breaker = newCircuitBreaker(config.BreakerThreshold, config.BreakerWindow, config.BreakerCooldown)
+}
+
+// checkUpstreamHost rejects URLs whose host isn't one of the allowed domains
+// or a subdomain of one, so a bad environment or template can't send bearer
+// tokens elsewhere.
+func checkUpstreamHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid upstream URL")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, suffix := range allowedUpstreamHosts {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return nil
+		}
+	}
+	return errors.Errorf("upstream host %q is not allowed", host)
+}
+
+// rejected reports whether the upstream refused the bearer token.
+func rejected(err error) bool {
+	status := upstreamStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// logCredential records which bearer token minted a token so we know when a
+// rotated-out credential is no longer in use.
+func logCredential(ctx context.Context, env environment, credential string) {
+	logger.Info("minted token with credential",
+		"request_id", requestID(ctx),
+		"environment", env.name,
+		"credential", credential,
+	)
+}
+
+// credentialsError is returned when an environment's bearer token can't be
+// loaded.
+type credentialsError struct {
+	err error
+}
+
+func (e *credentialsError) Error() string {
+	return fmt.Sprintf("could not load bearer token: %v", e.err)
+}
+
+func (e *credentialsError) Unwrap() error {
+	return e.err
+}
+
+// defaultUpstreamTimeout is used when UPSTREAM_TIMEOUT_SECONDS is unset.
+const defaultUpstreamTimeout = 5 * time.Second
+
+// upstreamClient is shared by every invocation so warm Lambdas reuse
+// connections and TLS sessions. http.Client is safe for concurrent use.
+var upstreamClient *http.Client
+
+func newUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		// Never follow redirects: the client would replay the Authorization
+		// header to wherever the upstream points. getToken reports them.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          20,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// deadlineMargin is kept free before the Lambda deadline so there is still
+// time to tell the user about a failure instead of being killed mid-request.
+const deadlineMargin = 250 * time.Millisecond
+
+// watchdogMargin is how long before the Lambda deadline a command still
+// waiting on the upstream is abandoned, leaving time to send a reply.
+const watchdogMargin = 500 * time.Millisecond
+
+// watchdogContext ends watchdogMargin before ctx's deadline. Without a
+// deadline, as when running as an HTTP server, it only adds a cancel.
+func watchdogContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-watchdogMargin))
+}
+
+// upstreamContext derives the context for an upstream call from the Lambda
+// invocation context so it ends deadlineMargin before Lambda's own deadline.
+func upstreamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+}
+
+// isTimeout reports whether err came from the upstream request running out
+// of time, either through the client timeout or the request context.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isCertificateError reports whether err came from the upstream's TLS
+// certificate failing verification, e.g. because it expired or names another
+// host. Verification is never turned off to get past one.
+func isCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &hostname)
+}
+
+// defaultMaxResponseBytes caps an upstream response body unless
+// MAX_RESPONSE_BYTES overrides it. Tokens are tiny, so anything near this is
+// anomalous.
+const defaultMaxResponseBytes = 64 << 10
+
+// maxResponseBytes is the Config's MaxResponseBytes, set by
+// configureUpstream.
+var maxResponseBytes int64 = defaultMaxResponseBytes
+
+// errResponseTooLarge is returned for an upstream response over
+// maxResponseBytes. The upstream answered, so it neither trips the breaker
+// nor is worth retrying.
+var errResponseTooLarge = errors.New("upstream response is too large")
+
+// maxErrorBodyLength bounds how much of an upstream error body is kept.
+const maxErrorBodyLength = 256
+
+// upstreamError is returned by getToken when the submission server responds
+// with anything other than 200 OK.
+type upstreamError struct {
+	statusCode int
+	body       string
+	// retryAfter is how long the upstream asked us to wait, or zero
+	retryAfter time.Duration
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("upstream returned %v: %v", e.statusCode, e.body)
+}
+
+// parseRetryAfter reads a Retry-After header in either its delta-seconds or
+// HTTP-date form, returning zero when it is absent or unreadable.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// upstreamStatus returns the upstream HTTP status implied by a getToken
+// result, or 0 when no response was received.
+func upstreamStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.statusCode
+	}
+	return 0
+}
+
+// tokenResponse is the JSON shape the upstream may answer with.
+type tokenResponse struct {
+	Token     string     `json:"token"`
+	Remaining *int       `json:"remaining"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func getToken(ctx context.Context, env environment, bearerToken string) (Token, error) {
+	ctx, cancel := upstreamContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, env.tokenMethod(), env.tokenURL(), nil)
+	if err != nil {
+		return Token{}, err
+	}
+
+	env.setHeaders(req)
+	env.authorize(req, bearerToken)
+	env.sign(req, nil, time.Now())
+
+	start := time.Now()
+	res, err := upstreamClient.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+
+	defer res.Body.Close()
+
+	logger.Debug("upstream responded",
+		"request_id", requestID(ctx),
+		"environment", env.name,
+		"status", res.StatusCode,
+		"latency_ms", time.Since(start).Milliseconds(),
+	)
+
+	// Read one byte past the cap so an oversized body can be told apart from
+	// one that fits exactly.
+	limit := maxResponseBytes
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return Token{}, err
+	}
+	if int64(len(body)) > limit {
+		return Token{}, errors.Wrapf(errResponseTooLarge, "over %v bytes", limit)
+	}
+
+	if res.StatusCode >= 300 && res.StatusCode < 400 {
+		return Token{}, &upstreamError{
+			statusCode: res.StatusCode,
+			body:       fmt.Sprintf("unexpected redirect to %q", res.Header.Get("Location")),
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		if len(body) > maxErrorBodyLength {
+			body = body[:maxErrorBodyLength]
+		}
+		return Token{}, &upstreamError{
+			statusCode: res.StatusCode,
+			body:       string(body),
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	// Older servers answer with the bare token as plain text.
+	var token Token
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != "application/json" {
+		token = Token{Value: strings.TrimSuffix(string(body), "\n")}
+	} else {
+		var parsed tokenResponse
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return Token{}, errors.Wrapf(errMalformedToken, "invalid token response: %v", err)
+		}
+		token = Token{Value: parsed.Token, Remaining: parsed.Remaining, Expires: parsed.ExpiresAt}
+	}
+
+	if err := env.validateToken(token.Value); err != nil {
+		return Token{}, err
+	}
+
+	token.Expires = tokenExpiry(token.Expires, res.Header, env)
+	return token, nil
+}
+
+// tokenExpiry picks the best known expiry: the JSON field, then the Expires
+// header, then the environment's tokenTTL from now. It returns nil when none
+// are available.
+func tokenExpiry(fromBody *time.Time, header http.Header, env environment) *time.Time {
+	if fromBody != nil {
+		return fromBody
+	}
+	// Caches are often told a response is already stale with a past
+	// Expires, which says nothing about the token.
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil && expires.After(time.Now()) {
+		return &expires
+	}
+	if env.tokenTTL > 0 {
+		expires := time.Now().Add(env.tokenTTL)
+		return &expires
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testUpstream serves handler as an environment's submission server and
+// allows its host for the test.
+func testUpstream(t *testing.T, handler http.HandlerFunc) environment {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	testUpstreamClient(t)
+
+	return environment{
+		name:        "Test",
+		secretName:  "TEST",
+		baseURL:     srv.URL,
+		tokenFormat: regexp.MustCompile(defaultTokenPattern),
+	}
+}
+
+// testUpstreamClient gives the test a fresh upstreamClient that may call
+// local servers. ALLOWED_UPSTREAM_HOSTS is set too so a testApp built
+// afterwards allows them as well.
+func testUpstreamClient(t *testing.T) {
+	t.Helper()
+	t.Setenv("ALLOWED_UPSTREAM_HOSTS", "127.0.0.1")
+	restoreUpstream(t)
+
+	upstreamClient = newUpstreamClient(defaultUpstreamTimeout)
+	allowedUpstreamHosts = []string{"127.0.0.1"}
+}
+
+// restoreUpstream puts back the upstream state shared across the package
+// when the test ends, so the test is free to change it.
+func restoreUpstream(t *testing.T) {
+	t.Helper()
+	client, hosts, responseBytes, retries, budget, b := upstreamClient, allowedUpstreamHosts, maxResponseBytes, maxRetries, retryBudget, breaker
+	t.Cleanup(func() {
+		upstreamClient, allowedUpstreamHosts, maxResponseBytes, maxRetries, retryBudget, breaker = client, hosts, responseBytes, retries, budget, b
+	})
+}
+
+func TestGetTokenCancelled(t *testing.T) {
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := getToken(ctx, env, "bearer")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("getToken() = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("getToken() took %v after cancellation", elapsed)
+	}
+}
+
+func TestGetTokenRedirect(t *testing.T) {
+	var followed int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&followed, 1)
+		w.Write([]byte("TOKEN1234"))
+	}))
+	defer target.Close()
+
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, target.URL+"/token", http.StatusFound)
+	})
+
+	_, err := getToken(context.Background(), env, "bearer")
+	var upErr *upstreamError
+	if !errors.As(err, &upErr) || upErr.statusCode != http.StatusFound {
+		t.Fatalf("getToken() = %v, want an upstreamError with status 302", err)
+	}
+	if n := atomic.LoadInt32(&followed); n != 0 {
+		t.Errorf("redirect target received %v requests, want 0", n)
+	}
+}
+
+func TestMintOversizedResponse(t *testing.T) {
+	var requests int32
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(strings.Repeat("A", 64)))
+	})
+	t.Setenv("TEST", "bearer")
+	maxResponseBytes = 32
+	maxRetries = 3
+	breaker = newCircuitBreaker(1, defaultBreakerWindow, defaultBreakerCooldown)
+
+	source := httpSource{secrets: envSecrets{}}
+	for i := 0; i < 2; i++ {
+		if _, err := source.Mint(context.Background(), env); !errors.Is(err, errResponseTooLarge) {
+			t.Fatalf("Mint() = %v, want errResponseTooLarge", err)
+		}
+	}
+	// Each mint made one request: no retries, and the breaker stayed closed.
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("upstream received %v requests, want 2", n)
+	}
+}
+
+func TestGetTokenInvalidJSON(t *testing.T) {
+	var requests int32
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token": `))
+	})
+	maxRetries = 3
+
+	if _, err := getTokenWithRetry(context.Background(), env, "bearer"); !errors.Is(err, errMalformedToken) {
+		t.Fatalf("getTokenWithRetry() = %v, want errMalformedToken", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("upstream received %v requests, want 1", n)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{name: "empty", value: ""},
+		{name: "seconds", value: "3", min: 3 * time.Second, max: 3 * time.Second},
+		{name: "zero seconds", value: "0"},
+		{name: "negative seconds", value: "-5"},
+		{
+			name:  "HTTP date",
+			value: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat),
+			min:   8 * time.Second,
+			max:   10 * time.Second,
+		},
+		{name: "past HTTP date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+		{name: "garbage", value: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestGetTokenSendsExtraHeaders(t *testing.T) {
+	var received http.Header
+	env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+		w.Write([]byte("TOKEN1234"))
+	})
+	env.extraHeaders = map[string]string{"X-Tenant": "covid-alert", "X-Api-Version": "2"}
+
+	if _, err := getToken(context.Background(), env, "bearer"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range env.extraHeaders {
+		if got := received.Get(name); got != want {
+			t.Errorf("%v = %q, want %q", name, got, want)
+		}
+	}
+	if got := received.Get("Authorization"); got != "Bearer bearer" {
+		t.Errorf("Authorization = %q, want the bearer token", got)
+	}
+}
+
+func TestGetTokenAuthSchemes(t *testing.T) {
+	tests := []struct {
+		scheme string
+		header string
+		want   string
+	}{
+		{scheme: "", header: "Authorization", want: "Bearer bearer-token"},
+		{scheme: authBearer, header: "Authorization", want: "Bearer bearer-token"},
+		{scheme: authToken, header: "Authorization", want: "Token bearer-token"},
+		{scheme: "header:X-Api-Key", header: "X-Api-Key", want: "bearer-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			var received http.Header
+			env := testUpstream(t, func(w http.ResponseWriter, req *http.Request) {
+				received = req.Header.Clone()
+				w.Write([]byte("TOKEN1234"))
+			})
+			env.authScheme = tt.scheme
+			if err := env.validateAuthScheme(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := getToken(context.Background(), env, "bearer-token"); err != nil {
+				t.Fatal(err)
+			}
+			if got := received.Get(tt.header); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.header, got, tt.want)
+			}
+			if tt.header != "Authorization" && received.Get("Authorization") != "" {
+				t.Errorf("Authorization = %q, want it unset", received.Get("Authorization"))
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+// Verification failures callers can tell apart. errBadSignature is returned
+// in place of slack-go's error, which embeds the expected and computed
+// signatures.
+var (
+	errMissingHeaders = errors.New("missing signature headers")
+	errBadSignature   = errors.New("signature verification failed")
+	errUnknownTeam    = errors.New("no signing secret for workspace")
+	errBodyTooLarge   = errors.New("request body is too large")
+)
+
+// errStaleRequest is returned for requests whose timestamp falls outside the
+// replay window.
+var errStaleRequest = errors.New("request timestamp is outside the replay window")
+
+// checkTimestamp rejects requests whose X-Slack-Request-Timestamp is more than
+// window away from now, to harden against replayed requests.
+func checkTimestamp(header http.Header, window time.Duration) error {
+	seconds, err := strconv.ParseInt(header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid request timestamp")
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > window || age < -window {
+		return errStaleRequest
+	}
+	return nil
+}
+
+// signingSecretFunc returns the signing secrets a Slack workspace's requests
+// may be signed with: the current one, then any still accepted during a
+// rotation.
+type signingSecretFunc func(ctx context.Context, enterpriseID, teamID string) ([]string, error)
+
+// secretLabels names signing secrets by position in logs.
+var secretLabels = []string{"current", "previous"}
+
+// verifyRequest checks that req was signed by Slack under any of the
+// workspace's secrets, as supplied by signingSecret. The body is read to find
+// the workspace and is then reset so handlers can parse it again.
+func verifyRequest(req *http.Request, signingSecret signingSecretFunc, replayWindow time.Duration, maxBodyBytes int64) error {
+	if req.Header.Get("X-Slack-Signature") == "" || req.Header.Get("X-Slack-Request-Timestamp") == "" {
+		return errMissingHeaders
+	}
+
+	if err := checkTimestamp(req.Header, replayWindow); err != nil {
+		return err
+	}
+
+	// Read one byte past the cap so an oversized body can be told apart from
+	// one that fits exactly.
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodyBytes+1))
+	if err != nil {
+		return errors.Wrap(err, "ReadAll failed")
+	}
+	if int64(len(body)) > maxBodyBytes {
+		return errBodyTooLarge
+	}
+
+	// Reset the body so SlashCommandParse and PostFormValue downstream read
+	// the same bytes that were verified.
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	enterpriseID, teamID := workspace(body)
+	secrets, err := signingSecret(req.Context(), enterpriseID, teamID)
+	if err != nil {
+		return err
+	}
+
+	for i, secret := range secrets {
+		secretVerifier, err := slack.NewSecretsVerifier(req.Header, secret)
+		if err != nil {
+			return errors.Wrap(err, "NewSecretsVerifier failed")
+		}
+
+		_, err = secretVerifier.Write(body)
+		if err != nil {
+			return errors.Wrap(err, "Write failed")
+		}
+
+		// Ensure compares with hmac.Equal, so the check is constant time.
+		if secretVerifier.Ensure() == nil {
+			logger.Debug("request verified", "request_id", requestID(req.Context()), "signing_secret", secretLabels[i])
+			return nil
+		}
+	}
+
+	return errBadSignature
+}
+
+// workspace finds the Enterprise Grid organization and workspace IDs in a
+// slash command form or an interaction payload. The enterprise ID is empty
+// outside Enterprise Grid. Both are unverified and only used to pick the
+// signing secret.
+func workspace(body []byte) (enterpriseID, teamID string) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", ""
+	}
+
+	if payload := form.Get("payload"); payload != "" {
+		return payloadWorkspace(payload)
+	}
+	return form.Get("enterprise_id"), form.Get("team_id")
+}
+
+// payloadWorkspace reads the enterprise and team IDs from an interaction
+// payload. slack.InteractionCallback has no enterprise field, and Slack sends
+// it either at the top level or on the team depending on the app.
+func payloadWorkspace(payload string) (enterpriseID, teamID string) {
+	var callback struct {
+		Enterprise *struct {
+			ID string `json:"id"`
+		} `json:"enterprise"`
+		Team struct {
+			ID           string `json:"id"`
+			EnterpriseID string `json:"enterprise_id"`
+		} `json:"team"`
+	}
+	json.Unmarshal([]byte(payload), &callback)
+	enterpriseID = callback.Team.EnterpriseID
+	if callback.Enterprise != nil && callback.Enterprise.ID != "" {
+		enterpriseID = callback.Enterprise.ID
+	}
+	return enterpriseID, callback.Team.ID
+}
+
+// signingSecret returns the signing secrets for teamID. When per-workspace
+// secrets are configured, inline or in the secrets backend, unknown teams are
+// rejected, unless the secrets list their Enterprise Grid organization, whose
+// org-wide apps share one secret; otherwise every team shares
+// SLACK_SIGNING_SECRET. Either way SLACK_SIGNING_SECRET_PREVIOUS is also
+// accepted while it is being rotated out.
+func (a *app) signingSecret(ctx context.Context, enterpriseID, teamID string) ([]string, error) {
+	raw := a.config.SigningSecrets
+	if raw == "" && a.config.SigningSecretsName != "" {
+		var err error
+		raw, err = a.secrets.secret(ctx, a.config.SigningSecretsName)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load signing secrets")
+		}
+	}
+
+	secrets := []string{a.config.SigningSecret}
+	if a.config.PreviousSigningSecret != "" {
+		secrets = append(secrets, a.config.PreviousSigningSecret)
+	}
+	if raw != "" {
+		var teams map[string]string
+		if err := json.Unmarshal([]byte(raw), &teams); err != nil {
+			return nil, errors.Wrap(err, "invalid signing secrets")
+		}
+		secret := teams[teamID]
+		if secret == "" && enterpriseID != "" {
+			secret = teams[enterpriseID]
+		}
+		secrets = []string{secret}
+		// The previous secret still has to be accepted during a rotation.
+		if a.config.PreviousSigningSecret != "" {
+			secrets = append(secrets, a.config.PreviousSigningSecret)
+		}
+	}
+
+	// An empty key would let anyone compute a valid signature.
+	if secrets[0] == "" {
+		return nil, errors.Wrapf(errUnknownTeam, "team %q in enterprise %q", teamID, enterpriseID)
+	}
+	return secrets, nil
+}
+
+// verificationFailed answers a request rejected by verifyRequest with a JSON
+// error: 400 when the signature headers are missing, 413 when the body is
+// over the cap, 401 otherwise.
+func verificationFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, errMissingHeaders) {
+		writeError(w, http.StatusBadRequest, codeUnauthorized, errMissingHeaders.Error())
+		return
+	}
+	if errors.Is(err, errBodyTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeInvalidCommand, errBodyTooLarge.Error())
+		return
+	}
+	writeError(w, http.StatusUnauthorized, codeUnauthorized, errBadSignature.Error())
+}
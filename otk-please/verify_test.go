@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/slack-go/slack"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	const window = time.Minute
+	tests := []struct {
+		name    string
+		age     time.Duration
+		wantErr error
+	}{
+		{name: "now", age: 0},
+		{name: "just inside the window", age: window - 5*time.Second},
+		{name: "just outside the window", age: window + 5*time.Second, wantErr: errStaleRequest},
+		{name: "hours old", age: 3 * time.Hour, wantErr: errStaleRequest},
+		{name: "just inside the window in the future", age: -window + 5*time.Second},
+		{name: "just outside the window in the future", age: -window - 5*time.Second, wantErr: errStaleRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("X-Slack-Request-Timestamp", strconv.FormatInt(time.Now().Add(-tt.age).Unix(), 10))
+
+			err := checkTimestamp(header, window)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkTimestamp() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckTimestampMalformed(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", "yesterday")
+
+	if err := checkTimestamp(header, time.Minute); err == nil {
+		t.Error("checkTimestamp() accepted a malformed timestamp")
+	}
+}
+
+func TestVerificationFailures(t *testing.T) {
+	body := slashForm("U0001", "demo").Encode()
+	tests := []struct {
+		name      string
+		request   func() *http.Request
+		status    int
+		wantError string
+	}{
+		{
+			name: "missing headers",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			},
+			status:    http.StatusBadRequest,
+			wantError: errMissingHeaders.Error(),
+		},
+		{
+			name: "malformed timestamp",
+			request: func() *http.Request {
+				req := slashRequest(slashForm("U0001", "demo"))
+				req.Header.Set("X-Slack-Request-Timestamp", "not-a-number")
+				return req
+			},
+			status:    http.StatusUnauthorized,
+			wantError: errBadSignature.Error(),
+		},
+		{
+			name: "body too large",
+			request: func() *http.Request {
+				return signedRequest("/", testSigningSecret, time.Now(), body+"&padding="+strings.Repeat("x", 4096))
+			},
+			status:    http.StatusRequestEntityTooLarge,
+			wantError: errBodyTooLarge.Error(),
+		},
+		{
+			name: "bad signature",
+			request: func() *http.Request {
+				return signedRequest("/", "not-the-secret", time.Now(), body)
+			},
+			status:    http.StatusUnauthorized,
+			wantError: errBadSignature.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, staticSource("TOKEN1234"), map[string]string{"MAX_BODY_BYTES": "1024"})
+			req := tt.request()
+			signature := req.Header.Get("X-Slack-Signature")
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %v, want %v", rec.Code, tt.status)
+			}
+			var doc struct {
+				Error     string    `json:"error"`
+				ErrorCode errorCode `json:"error_code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+			}
+			if doc.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", doc.Error, tt.wantError)
+			}
+			if signature != "" && strings.Contains(rec.Body.String(), strings.TrimPrefix(signature, "v0=")) {
+				t.Error("error body leaks the signature")
+			}
+			if strings.Contains(rec.Body.String(), testSigningSecret) {
+				t.Error("error body leaks the signing secret")
+			}
+		})
+	}
+}
+
+func TestVerifyPerWorkspaceRotation(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{
+		"SLACK_SIGNING_SECRETS":         `{"T0001": "workspace-secret"}`,
+		"SLACK_SIGNING_SECRET_PREVIOUS": "previous-secret",
+	})
+	body := slashForm("U0001", "demo").Encode()
+
+	tests := []struct {
+		name   string
+		secret string
+		want   error
+	}{
+		{name: "current", secret: "workspace-secret"},
+		{name: "previous", secret: "previous-secret"},
+		{name: "neither", secret: testSigningSecret, want: errBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest("/", tt.secret, time.Now(), body)
+			err := verifyRequest(req, a.signingSecret, a.config.ReplayWindow, int64(a.config.MaxBodyBytes))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("verifyRequest() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	a := testApp(t, staticSource("TOKEN1234"), nil)
+	body := slashForm("U0001", "demo 3").Encode()
+	verify := func(req *http.Request) error {
+		return verifyRequest(req, a.signingSecret, a.config.ReplayWindow, int64(a.config.MaxBodyBytes))
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		req := signedRequest("/", testSigningSecret, time.Now(), body)
+		if err := verify(req); err != nil {
+			t.Fatalf("verifyRequest() = %v", err)
+		}
+		// The body was reset, so the command can still be parsed in full.
+		s, err := slack.SlashCommandParse(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Text != "demo 3" || s.UserID != "U0001" || s.TeamID != "T0001" {
+			t.Errorf("SlashCommandParse() = %+v, want the signed command", s)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := signedRequest("/", testSigningSecret, time.Now(), body)
+		req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Replace(body, "demo", "prod", 1))).Body
+		if err := verify(req); !errors.Is(err, errBadSignature) {
+			t.Errorf("verifyRequest() = %v, want errBadSignature", err)
+		}
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		req := signedRequest("/", testSigningSecret, time.Now(), body)
+		signature := []byte(req.Header.Get("X-Slack-Signature"))
+		if signature[len(signature)-1] == '0' {
+			signature[len(signature)-1] = '1'
+		} else {
+			signature[len(signature)-1] = '0'
+		}
+		req.Header.Set("X-Slack-Signature", string(signature))
+		if err := verify(req); !errors.Is(err, errBadSignature) {
+			t.Errorf("verifyRequest() = %v, want errBadSignature", err)
+		}
+	})
+}
+
+func TestVerifyBodyAtLimit(t *testing.T) {
+	body := slashForm("U0001", "demo").Encode()
+	a := testApp(t, staticSource("TOKEN1234"), map[string]string{"MAX_BODY_BYTES": strconv.Itoa(len(body))})
+
+	err := verifyRequest(signedRequest("/", testSigningSecret, time.Now(), body), a.signingSecret, a.config.ReplayWindow, int64(a.config.MaxBodyBytes))
+	if err != nil {
+		t.Errorf("verifyRequest() of a body exactly at the limit = %v", err)
+	}
+}
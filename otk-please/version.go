@@ -0,0 +1,8 @@
+package main
+
+// Build information, set at build time with -ldflags "-X main.version=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
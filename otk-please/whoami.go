@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// whoami describes who Slack says the caller is and what they may do, for
+// debugging "I can't access staging". It runs after verification like any
+// other command but before the user allowlist, so blocked users can use it.
+func (a *app) whoami(l locale, s slack.SlashCommand) string {
+	allowed := a.config.userAllowed(s.UserID) && a.config.enterpriseAllowed(s.EnterpriseID)
+
+	var names []string
+	if allowed {
+		for _, key := range a.environmentKeys() {
+			env := a.config.Environments[key]
+			if env.channelAllowed(s.ChannelID) && a.config.envAllowed(env, s.UserID) {
+				names = append(names, fmt.Sprintf("*%v*", key))
+			}
+		}
+	}
+	environments := strings.Join(names, ", ")
+	if len(names) == 0 {
+		environments = l.text(msgWhoamiNone)
+	}
+
+	enterprise := s.EnterpriseID
+	if enterprise == "" {
+		enterprise = l.text(msgWhoamiNone)
+	}
+
+	return l.text(msgWhoami, s.UserID, s.TeamID, enterprise, s.ChannelID,
+		yesNo(l, allowed), yesNo(l, a.config.isAdmin(s.UserID)), environments)
+}
+
+func yesNo(l locale, value bool) string {
+	if value {
+		return l.text(msgYes)
+	}
+	return l.text(msgNo)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWhoami(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+		not  []string
+	}{
+		{
+			name: "allowed user",
+			want: []string{"User: `U0001`", "Team: `T0001`", "Enterprise: `none`", "Channel: `C0001`", "Allowed: yes", "Admin: no", "*demo*", "*staging*"},
+		},
+		{
+			name: "admin",
+			env:  map[string]string{"ADMIN_USERS": "U0001"},
+			want: []string{"Allowed: yes", "Admin: yes"},
+		},
+		{
+			name: "blocked user",
+			env:  map[string]string{"ALLOWED_USERS": "U0002"},
+			want: []string{"User: `U0001`", "Allowed: no", "Environments here: none"},
+			not:  []string{"*demo*"},
+		},
+		{
+			name: "environment limited to other users",
+			env:  map[string]string{"STAGING_ALLOWED_USERS": "U0002"},
+			want: []string{"*demo*"},
+			not:  []string{"*staging*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testApp(t, staticSource("TOKEN1234"), tt.env)
+
+			rec := httptest.NewRecorder()
+			a.handler(rec, slashRequest(slashForm("U0001", "whoami")))
+
+			text := decodeResponse(t, rec).Text
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("whoami %q does not contain %q", text, want)
+				}
+			}
+			for _, not := range tt.not {
+				if strings.Contains(text, not) {
+					t.Errorf("whoami %q contains %q", text, not)
+				}
+			}
+		})
+	}
+}